@@ -1,19 +1,35 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+	// Time zones for notification settings, which the base image lacks
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"github.com/roadeye/backend/internal/apikeys"
 	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/comments"
 	"github.com/roadeye/backend/internal/db"
+	"github.com/roadeye/backend/internal/events"
 	"github.com/roadeye/backend/internal/handlers"
 	"github.com/roadeye/backend/internal/hazards"
+	"github.com/roadeye/backend/internal/idempotency"
+	"github.com/roadeye/backend/internal/mail"
+	"github.com/roadeye/backend/internal/notifications"
+	"github.com/roadeye/backend/internal/ratelimit"
+	"github.com/roadeye/backend/internal/users"
+	"github.com/roadeye/backend/internal/webhooks"
+	"github.com/roadeye/backend/pkg/models"
 )
 
 func main() {
@@ -39,18 +55,111 @@ func main() {
 	}
 	defer database.Close()
 
+	// Connect to Redis
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:     getEnv("REDIS_HOST", "localhost") + ":" + getEnv("REDIS_PORT", "6379"),
+		Password: getEnv("REDIS_PASSWORD", ""),
+		DB:       0,
+	})
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+	defer redisClient.Close()
+
+	// Event bus shared with the worker, and the hub feeding live streams
+	bus := events.NewBus(redisClient)
+	hub := events.NewHub(bus)
+	go hub.Run(context.Background())
+
+	// Idempotency-Key support for mutating endpoints
+	idempotencyTTL, _ := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
+	idempotencyStore := idempotency.NewStore(redisClient, idempotencyTTL)
+
+	// Rate limiting; each public auth route gets a much stricter policy of
+	// its own, and authenticated routes are limited per address before the
+	// credentials are looked up
+	var rateBackend ratelimit.Backend = ratelimit.NewRedisBackend(redisClient)
+	if getEnv("RATE_LIMIT_BACKEND", "redis") == "memory" {
+		rateBackend = ratelimit.NewMemoryBackend()
+	}
+	limiter := ratelimit.NewLimiter(rateBackend)
+	defaultLimit := limiter.Middleware(rateLimitPolicy("default", "RATE_LIMIT", 100, time.Minute))
+	registerLimit := limiter.Middleware(rateLimitPolicy("register", "RATE_LIMIT_REGISTER", 5, time.Hour))
+	loginLimit := limiter.Middleware(rateLimitPolicy("login", "RATE_LIMIT_LOGIN", 10, 15*time.Minute))
+	unlockLimit := limiter.Middleware(rateLimitPolicy("unlock", "RATE_LIMIT_UNLOCK", 5, 15*time.Minute))
+	twoFactorLimit := limiter.Middleware(rateLimitPolicy("2fa", "RATE_LIMIT_2FA", 10, 15*time.Minute))
+	oidcAuthorizeLimit := limiter.Middleware(rateLimitPolicy("oidc_authorize", "RATE_LIMIT_OIDC_AUTHORIZE", 30, 15*time.Minute))
+	oidcCallbackLimit := limiter.Middleware(rateLimitPolicy("oidc_callback", "RATE_LIMIT_OIDC_CALLBACK", 10, 15*time.Minute))
+	oidcTokenLimit := limiter.Middleware(rateLimitPolicy("oidc_token", "RATE_LIMIT_OIDC_TOKEN", 10, 15*time.Minute))
+	addressLimit := limiter.Middleware(rateLimitPolicy("address", "RATE_LIMIT_ADDRESS", 600, time.Minute))
+
 	// Initialize JWT manager
 	jwtSecret := getEnv("JWT_SECRET", "your-secret-key")
 	tokenExpiry, _ := time.ParseDuration(getEnv("JWT_EXPIRY", "24h"))
 	refreshExpiry, _ := time.ParseDuration(getEnv("JWT_REFRESH_EXPIRY", "168h"))
-	jwtManager := auth.NewJWTManager(jwtSecret, tokenExpiry, refreshExpiry)
+	accountCacheTTL, _ := time.ParseDuration(getEnv("ACCOUNT_CACHE_TTL", "30s"))
+	accountStore := auth.NewAccountStore(database, accountCacheTTL)
+	jwtManager := auth.NewJWTManager(jwtSecret, tokenExpiry, refreshExpiry, accountStore)
+
+	// Partner API keys, accepted alongside JWTs
+	apiKeyCacheTTL, _ := time.ParseDuration(getEnv("API_KEY_CACHE_TTL", "30s"))
+	apiKeyStore := auth.NewAPIKeyStore(database, apiKeyCacheTTL)
+	authenticator := auth.NewAuthenticator(jwtManager, apiKeyStore)
+
+	// Password hashing and policy
+	argon2Params := auth.DefaultArgon2Params
+	argon2Params.Memory = uint32(getEnvInt("PASSWORD_ARGON2_MEMORY_KIB", int(argon2Params.Memory)))
+	argon2Params.Iterations = uint32(getEnvInt("PASSWORD_ARGON2_ITERATIONS", int(argon2Params.Iterations)))
+	argon2Params.Parallelism = uint8(getEnvInt("PASSWORD_ARGON2_PARALLELISM", int(argon2Params.Parallelism)))
+	passwordManager := auth.NewPasswordManager(argon2Params, auth.PasswordPolicy{
+		MinLength:    getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		RejectCommon: getEnv("PASSWORD_REJECT_COMMON", "true") == "true",
+	})
+
+	// Failed-login tracking and outgoing mail
+	loginGuard := auth.NewLoginGuard(redisClient)
+	var mailer mail.Mailer = mail.LogMailer{}
+	if smtpHost := getEnv("SMTP_HOST", ""); smtpHost != "" {
+		smtpMailer, err := mail.NewSMTPMailer(smtpHost, getEnv("SMTP_PORT", "587"),
+			getEnv("SMTP_USERNAME", ""), getEnv("SMTP_PASSWORD", ""), getEnv("MAIL_FROM", "RoadEye <no-reply@roadeye.app>"))
+		if err != nil {
+			log.Fatal("Invalid mail configuration:", err)
+		}
+		mailer = smtpMailer
+	}
 
 	// Initialize repositories
 	hazardRepo := hazards.NewRepository(database)
+	commentRepo := comments.NewRepository(database)
+	commentFilter := comments.Chain{
+		comments.URLFilter{},
+		comments.NewProfanityFilter(comments.DefaultBlocklist),
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(database, jwtManager)
-	hazardHandler := handlers.NewHazardHandler(hazardRepo)
+	authHandler := handlers.NewAuthHandler(database, jwtManager, passwordManager, loginGuard, idempotencyStore, mailer, getEnv("APP_URL", "http://localhost:3000"))
+	hazardHandler := handlers.NewHazardHandler(hazardRepo, bus)
+	streamHandler := handlers.NewStreamHandler(hub)
+	commentHandler := handlers.NewCommentHandler(commentRepo, hazardRepo, commentFilter, bus)
+	moderationHandler := handlers.NewModerationHandler(hazardRepo, accountStore, bus)
+	deletionGrace, _ := time.ParseDuration(getEnv("ACCOUNT_DELETION_GRACE", "720h"))
+	apiKeyHandler := handlers.NewAPIKeyHandler(apikeys.NewRepository(database), apiKeyStore)
+	userHandler := handlers.NewUserHandler(users.NewRepository(database), passwordManager, deletionGrace)
+	webhookHandler := handlers.NewWebhookHandler(webhooks.NewRepository(database))
+	notificationHandler := handlers.NewNotificationHandler(notifications.NewRepository(database, notificationRadiusKm()))
+
+	// Sign-in with OpenID Connect providers that have client IDs configured
+	oidcRedirectURL := getEnv("OIDC_REDIRECT_URL", getEnv("APP_URL", "http://localhost:3000")+"/auth/callback")
+	var oidcProviders []*auth.OIDCProvider
+	for _, p := range []struct{ name, issuer string }{
+		{"google", "https://accounts.google.com"},
+		{"apple", "https://appleid.apple.com"},
+	} {
+		if provider := oidcProvider(p.name, p.issuer, oidcRedirectURL); provider != nil {
+			oidcProviders = append(oidcProviders, provider)
+		}
+	}
+	oidcHandler := handlers.NewOIDCHandler(authHandler, auth.NewOIDCSessions(redisClient), oidcProviders...)
 
 	// Setup router
 	r := chi.NewRouter()
@@ -60,41 +169,140 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Timeout(60 * time.Second))
 
 	// CORS
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", idempotency.HeaderKey, auth.HeaderAPIKey},
+		ExposedHeaders:   append([]string{"Link", "ETag", idempotency.HeaderReplayed}, ratelimit.Headers...),
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	// Public routes
 	r.Group(func(r chi.Router) {
-		r.Post("/auth/register", authHandler.Register)
-		r.Post("/auth/login", authHandler.Login)
+		r.Use(middleware.Timeout(60 * time.Second))
+
+		r.With(registerLimit).Post("/auth/register", authHandler.Register)
+		r.With(loginLimit).Post("/auth/login", authHandler.Login)
+		r.With(unlockLimit).Post("/auth/unlock", authHandler.Unlock)
+		r.With(twoFactorLimit).Post("/auth/2fa/verify", authHandler.VerifyTwoFactor)
+		r.With(oidcAuthorizeLimit).Get("/auth/oidc/{provider}/authorize", oidcHandler.Authorize)
+		r.With(oidcCallbackLimit).Post("/auth/oidc/{provider}/callback", oidcHandler.Callback)
+		r.With(oidcTokenLimit).Post("/auth/oidc/{provider}/token", oidcHandler.Token)
 		r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
 		})
 	})
 
-	// Protected routes
+	// Protected routes. Partner API keys may only use the routes that
+	// require a scope; everything else is for users.
+	read := auth.RequireScope(models.ScopeHazardsRead)
+	write := auth.RequireScope(models.ScopeHazardsWrite)
+	feeds := auth.RequireScope(models.ScopeFeedsRead)
+	// Hazard and comment writes may be retried with an Idempotency-Key
+	idem := idempotencyStore.Middleware
+
 	r.Group(func(r chi.Router) {
-		r.Use(jwtManager.AuthMiddleware)
+		r.Use(middleware.Timeout(60 * time.Second))
+		r.Use(addressLimit)
+		r.Use(authenticator.Middleware)
+		r.Use(defaultLimit)
+
+		// Hazard routes open to API keys
+		r.With(write, idem).Post("/hazards/report", hazardHandler.Create)
+		r.With(write).Post("/hazards/batch", hazardHandler.CreateBatch)
+		r.With(write).Get("/hazards/quota", hazardHandler.Quota)
+		r.With(write, idem).Patch("/hazards/{id}", hazardHandler.Update)
+		r.With(write, idem).Delete("/hazards/{id}", hazardHandler.Delete)
+		r.With(read).Get("/hazards", hazardHandler.GetNearby)
+		r.With(read).Post("/hazards/route", hazardHandler.AlongRoute)
+		r.With(read).Get("/hazards/ahead", hazardHandler.Ahead)
+		r.With(read).Get("/hazards/{id}", hazardHandler.GetByID)
+		r.With(feeds).Get("/hazards/changes", hazardHandler.Changes)
+		r.With(feeds).Put("/hazards/stream/{id}/area", streamHandler.UpdateArea)
+
+		// Webhook routes, for the caller's own subscriptions
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireScope(models.ScopeWebhooksManage))
+
+			r.Post("/webhooks", webhookHandler.Create)
+			r.Get("/webhooks", webhookHandler.List)
+			r.Get("/webhooks/{id}", webhookHandler.Get)
+			r.Patch("/webhooks/{id}", webhookHandler.Update)
+			r.Delete("/webhooks/{id}", webhookHandler.Delete)
+			r.Get("/webhooks/{id}/deliveries", webhookHandler.Deliveries)
+			r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", webhookHandler.Redeliver)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireUser)
+
+			// Auth routes
+			r.Get("/auth/profile", authHandler.GetProfile)
+			r.Post("/auth/2fa/setup", authHandler.SetupTwoFactor)
+			r.Post("/auth/2fa/enable", authHandler.EnableTwoFactor)
+			r.Post("/auth/2fa/disable", authHandler.DisableTwoFactor)
+			r.Post("/auth/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+
+			// Account routes
+			r.Delete("/users/me", userHandler.Delete)
+			r.Post("/users/me/deletion/cancel", userHandler.CancelDeletion)
+			r.Get("/users/me/export", userHandler.Export)
+			r.Put("/users/me/preferences", userHandler.PutPreferences)
+			r.Get("/users/me/notification-settings", notificationHandler.GetSettings)
+			r.Put("/users/me/notification-settings", notificationHandler.PutSettings)
+
+			// Notification inbox
+			r.Get("/notifications", notificationHandler.List)
+			r.Post("/notifications/read-all", notificationHandler.MarkAllRead)
+			r.Post("/notifications/{id}/read", notificationHandler.MarkRead)
+			r.Delete("/notifications/{id}", notificationHandler.Delete)
+
+			// Hazard routes
+			r.With(idem).Post("/hazards/{id}/verify", hazardHandler.Verify)
+			r.With(idem).Post("/hazards/{id}/flag", hazardHandler.Flag)
+			r.With(auth.RequireRole(models.UserRoleModerator, models.UserRoleAdmin)).
+				Get("/hazards/{id}/history", hazardHandler.History)
 
-		// Auth routes
-		r.Get("/auth/profile", authHandler.GetProfile)
+			// Comment routes
+			r.Get("/hazards/{id}/comments", commentHandler.List)
+			r.With(idem).Post("/hazards/{id}/comments", commentHandler.Create)
+			r.With(idem).Patch("/hazards/{id}/comments/{commentID}", commentHandler.Update)
+			r.With(idem).Delete("/hazards/{id}/comments/{commentID}", commentHandler.Delete)
+			r.With(idem).Post("/hazards/{id}/comments/{commentID}/report", commentHandler.Report)
 
-		// Hazard routes
-		r.Post("/hazards/report", hazardHandler.Create)
-		r.Get("/hazards", hazardHandler.GetNearby)
-		r.Get("/hazards/{id}", hazardHandler.GetByID)
-		r.Post("/hazards/{id}/verify", hazardHandler.Verify)
-		r.Delete("/hazards/{id}", hazardHandler.Delete)
+			// Moderation routes
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireRole(models.UserRoleModerator, models.UserRoleAdmin))
+
+				r.Get("/moderation/queue", moderationHandler.Queue)
+				r.Post("/moderation/hazards/{id}/actions", moderationHandler.Act)
+				r.Put("/moderation/users/{id}/status", moderationHandler.SetAccountStatus)
+			})
+
+			// Admin routes
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireRole(models.UserRoleAdmin))
+
+				r.Post("/admin/api-keys", apiKeyHandler.Create)
+				r.Get("/admin/api-keys", apiKeyHandler.List)
+				r.Get("/admin/api-keys/{id}", apiKeyHandler.Get)
+				r.Patch("/admin/api-keys/{id}", apiKeyHandler.Update)
+				r.Delete("/admin/api-keys/{id}", apiKeyHandler.Revoke)
+			})
+		})
+	})
+
+	// Streaming routes are long-lived and so sit outside the request timeout
+	r.Group(func(r chi.Router) {
+		r.Use(addressLimit)
+		r.Use(authenticator.Middleware)
+		r.Use(defaultLimit)
+
+		r.With(feeds).Get("/hazards/stream", streamHandler.Stream)
 	})
 
 	// Start server
@@ -111,3 +319,58 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt returns the positive integer in key, or defaultValue when unset
+// or invalid.
+func getEnvInt(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
+}
+
+// notificationRadiusKm reads NOTIFICATION_RADIUS_KM, the alert radius of
+// users who have not chosen one.
+func notificationRadiusKm() float64 {
+	if km, err := strconv.ParseFloat(os.Getenv("NOTIFICATION_RADIUS_KM"), 64); err == nil && km > 0 {
+		return km
+	}
+	return 3.0
+}
+
+// rateLimitPolicy reads <prefix>_REQUESTS and <prefix>_WINDOW, falling back
+// to the defaults when unset or invalid.
+func rateLimitPolicy(name, prefix string, requests int, window time.Duration) ratelimit.Policy {
+	if n, err := strconv.Atoi(os.Getenv(prefix + "_REQUESTS")); err == nil && n > 0 {
+		requests = n
+	}
+	if d, err := time.ParseDuration(os.Getenv(prefix + "_WINDOW")); err == nil && d > 0 {
+		window = d
+	}
+	return ratelimit.Policy{Name: name, Requests: requests, Window: window}
+}
+
+// oidcProvider reads OIDC_<NAME>_ISSUER, _CLIENT_IDS (comma-separated),
+// _CLIENT_SECRET and _JWKS_URL. It returns nil when no client IDs are set.
+func oidcProvider(name, issuer, redirectURL string) *auth.OIDCProvider {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+	var clientIDs []string
+	for _, id := range strings.Split(os.Getenv(prefix+"CLIENT_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			clientIDs = append(clientIDs, id)
+		}
+	}
+	if len(clientIDs) == 0 {
+		return nil
+	}
+
+	return auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:         name,
+		Issuer:       getEnv(prefix+"ISSUER", issuer),
+		ClientIDs:    clientIDs,
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		JWKSURL:      os.Getenv(prefix + "JWKS_URL"),
+		RedirectURL:  redirectURL,
+	})
+}
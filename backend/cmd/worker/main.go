@@ -5,21 +5,21 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"strconv"
 	"time"
+	// Time zones for quiet hours, which the base image lacks
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 	"github.com/roadeye/backend/internal/db"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/mail"
+	"github.com/roadeye/backend/internal/notifications"
+	"github.com/roadeye/backend/internal/users"
+	"github.com/roadeye/backend/internal/webhooks"
 )
 
-type HazardNotificationJob struct {
-	HazardID  string  `json:"hazard_id"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Type      string  `json:"type"`
-	Severity  string  `json:"severity"`
-}
-
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
@@ -56,39 +56,99 @@ func main() {
 
 	log.Println("Worker started, listening for jobs...")
 
-	// Subscribe to hazard notification channel
-	pubsub := redisClient.Subscribe(ctx, "hazard:notifications")
+	// Delete accounts whose grace period has ended
+	go purgeDeletedAccounts(ctx, users.NewRepository(database))
+
+	// Alerts go out by push and email according to each user's settings
+	var mailer mail.Mailer = mail.LogMailer{}
+	if smtpHost := getEnv("SMTP_HOST", ""); smtpHost != "" {
+		smtpMailer, err := mail.NewSMTPMailer(smtpHost, getEnv("SMTP_PORT", "587"),
+			getEnv("SMTP_USERNAME", ""), getEnv("SMTP_PASSWORD", ""), getEnv("MAIL_FROM", "RoadEye <no-reply@roadeye.app>"))
+		if err != nil {
+			log.Fatal("Invalid mail configuration:", err)
+		}
+		mailer = smtpMailer
+	}
+	digestWindow, _ := time.ParseDuration(getEnv("NOTIFICATION_DIGEST_WINDOW", "2m"))
+	throttle := notifications.NewThrottle(redisClient, notifications.ThrottleConfig{
+		MaxPerHazard:   getEnvInt("MAX_NOTIFICATIONS_PER_HAZARD", 100),
+		MaxPerUserHour: getEnvInt("MAX_NOTIFICATIONS_PER_USER_HOUR", 10),
+		DigestWindow:   digestWindow,
+	})
+	notifier := notifications.NewNotifier(
+		notifications.NewRepository(database, notificationRadiusKm()), notifications.LogPusher{}, mailer, throttle)
+	go notifier.RunDigests(ctx)
+
+	// Send queued webhook deliveries
+	webhookRepo := webhooks.NewRepository(database)
+	webhookTimeout, _ := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+	dispatcher := webhooks.NewDispatcher(webhookRepo, webhooks.DispatcherConfig{
+		MaxAttempts:          getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		DisableAfter:         getEnvInt("WEBHOOK_DISABLE_AFTER_FAILURES", 20),
+		Timeout:              webhookTimeout,
+		AllowPrivateNetworks: getEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "false") == "true",
+	})
+	go dispatcher.Run(ctx)
+
+	// Subscribe to the hazard event bus
+	pubsub := events.NewBus(redisClient).Subscribe(ctx, events.HazardChannel)
 	defer pubsub.Close()
 
 	ch := pubsub.Channel()
 
 	for msg := range ch {
-		var job HazardNotificationJob
-		if err := json.Unmarshal([]byte(msg.Payload), &job); err != nil {
-			log.Printf("Failed to unmarshal job: %v", err)
+		var event events.HazardEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			log.Printf("Failed to unmarshal event: %v", err)
+			continue
+		}
+
+		// Queue webhook deliveries for matching subscriptions
+		if _, err := webhookRepo.Enqueue(ctx, &event); err != nil {
+			log.Printf("Failed to queue webhook deliveries: %v", err)
+		}
+
+		if event.Hazard == nil {
 			continue
 		}
 
-		log.Printf("Processing notification for hazard %s", job.HazardID)
-		
-		// Process notification job
-		if err := processNotification(ctx, database, &job); err != nil {
-			log.Printf("Failed to process notification: %v", err)
+		switch event.Type {
+		case events.HazardCreated:
+			log.Printf("Processing notification for hazard %s", event.Hazard.ID)
+
+			if err := notifier.Notify(ctx, event.Hazard); err != nil {
+				log.Printf("Failed to process notification: %v", err)
+			}
+		case events.HazardCommented:
+			if event.Comment == nil {
+				continue
+			}
+			if err := notifier.NotifyComment(ctx, event.Hazard, event.Comment); err != nil {
+				log.Printf("Failed to process comment notification: %v", err)
+			}
 		}
 	}
 }
 
-func processNotification(ctx context.Context, db *db.DB, job *HazardNotificationJob) error {
-	// TODO: Implement notification logic
-	// 1. Find users within radius using PostGIS
-	// 2. Get their device tokens
-	// 3. Send FCM notifications
-	// 4. Log notifications in database
-	
-	log.Printf("Would send notifications for %s hazard at (%.6f, %.6f)",
-		job.Type, job.Latitude, job.Longitude)
-	
-	return nil
+func purgeDeletedAccounts(ctx context.Context, repo *users.Repository) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		purged, err := repo.PurgeDue(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to purge deleted accounts: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted accounts", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -97,3 +157,21 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt returns the positive integer in key, or defaultValue when unset
+// or invalid.
+func getEnvInt(key string, defaultValue int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return defaultValue
+}
+
+// notificationRadiusKm reads NOTIFICATION_RADIUS_KM, the alert radius of
+// users who have not chosen one.
+func notificationRadiusKm() float64 {
+	if km, err := strconv.ParseFloat(os.Getenv("NOTIFICATION_RADIUS_KM"), 64); err == nil && km > 0 {
+		return km
+	}
+	return 3.0
+}
@@ -0,0 +1,150 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+var (
+	ErrNotFound     = errors.New("API key not found")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+const keyColumns = `id, user_id, name, prefix, scopes, rate_limit, expires_at, last_used_at,
+		       revoked_at, created_by, created_at, updated_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func scanKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes []string
+	err := row.Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&scopes), &key.RateLimit,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedBy, &key.CreatedAt, &key.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = make([]models.APIKeyScope, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = models.APIKeyScope(scope)
+	}
+	return key, nil
+}
+
+// Create issues a key for req.UserID. The plaintext key is returned only
+// here.
+func (r *Repository) Create(ctx context.Context, req *models.APIKeyCreate, createdBy uuid.UUID) (*models.APIKeyCreated, error) {
+	plaintext, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, rate_limit, expires_at, created_by)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8 FROM users WHERE id = $1
+		RETURNING ` + keyColumns
+
+	key, err := scanKey(r.db.QueryRowContext(ctx, query,
+		req.UserID, req.Name, prefix, hash, pq.Array(scopeStrings(req.Scopes)), req.RateLimit, req.ExpiresAt, createdBy))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.APIKeyCreated{APIKey: key, Key: plaintext}, nil
+}
+
+// List returns all keys, newest first, optionally only those of userID.
+func (r *Repository) List(ctx context.Context, userID *uuid.UUID) ([]*models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+keyColumns+` FROM api_keys
+		WHERE $1::uuid IS NULL OR user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*models.APIKey, error) {
+	key, err := scanKey(r.db.QueryRowContext(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+// Update changes the given fields of a key that has not been revoked.
+func (r *Repository) Update(ctx context.Context, id uuid.UUID, req *models.APIKeyUpdate) (*models.APIKey, error) {
+	var scopes interface{}
+	if req.Scopes != nil {
+		scopes = pq.Array(scopeStrings(req.Scopes))
+	}
+
+	query := `
+		UPDATE api_keys SET
+			name = COALESCE($2, name),
+			scopes = COALESCE($3, scopes),
+			rate_limit = COALESCE($4, rate_limit),
+			expires_at = COALESCE($5, expires_at)
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING ` + keyColumns
+
+	key, err := scanKey(r.db.QueryRowContext(ctx, query, id, req.Name, scopes, req.RateLimit, req.ExpiresAt))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+// Revoke disables a key for good. The row is kept for auditing.
+func (r *Repository) Revoke(ctx context.Context, id uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scopeStrings(scopes []models.APIKeyScope) []string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// accountCacheSweep is the cache size at which expired entries are purged.
+const accountCacheSweep = 10000
+
+type cachedAccount struct {
+	account *models.Account
+	expires time.Time
+}
+
+// AccountStore looks up account states for AuthMiddleware. Lookups are
+// cached per replica for ttl, so a status change takes effect everywhere
+// within ttl even though tokens stay valid until they expire.
+type AccountStore struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[uuid.UUID]cachedAccount
+}
+
+func NewAccountStore(db *sql.DB, ttl time.Duration) *AccountStore {
+	return &AccountStore{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[uuid.UUID]cachedAccount),
+	}
+}
+
+func (s *AccountStore) Get(ctx context.Context, userID uuid.UUID) (*models.Account, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	cached, ok := s.cache[userID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.account, nil
+	}
+
+	account := &models.Account{UserID: userID}
+	err := s.db.QueryRowContext(ctx, `SELECT status, suspended_until, status_reason FROM users WHERE id = $1`, userID).
+		Scan(&account.Status, &account.SuspendedUntil, &account.Reason)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if len(s.cache) >= accountCacheSweep {
+		for id, c := range s.cache {
+			if !now.Before(c.expires) {
+				delete(s.cache, id)
+			}
+		}
+	}
+	s.cache[userID] = cachedAccount{account: account, expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+
+	return account, nil
+}
+
+// Invalidate drops a cached account after its status changed on this
+// replica.
+func (s *AccountStore) Invalidate(userID uuid.UUID) {
+	s.mu.Lock()
+	delete(s.cache, userID)
+	s.mu.Unlock()
+}
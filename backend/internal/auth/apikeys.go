@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	HeaderAPIKey = "X-API-Key"
+
+	apiKeyPrefix = "rk_"
+	// apiKeyDisplayLength is how much of a key is kept in clear to tell keys
+	// apart.
+	apiKeyDisplayLength = 11
+
+	// apiKeyTouchInterval limits last_used_at writes per key and replica.
+	apiKeyTouchInterval = time.Minute
+)
+
+var (
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key has expired")
+)
+
+// GenerateAPIKey returns a new random key, its display prefix and the hash
+// to store.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:apiKeyDisplayLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes a key for storage and lookup. Keys are random enough
+// that a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
+
+type cachedAPIKey struct {
+	key     *models.APIKey
+	expires time.Time
+	touched time.Time
+}
+
+// APIKeyStore looks up API keys for the auth middleware. Like AccountStore
+// it caches keys per replica for ttl, so a revoked key may keep working on
+// other replicas for up to ttl. Unknown keys are not cached, so the cache
+// holds no more than the keys that exist; guessing is held back by the
+// per-address rate limit in front of authentication instead.
+type APIKeyStore struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cachedAPIKey
+}
+
+func NewAPIKeyStore(db *sql.DB, ttl time.Duration) *APIKeyStore {
+	return &APIKeyStore{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[string]*cachedAPIKey),
+	}
+}
+
+// Authenticate returns the key matching the plaintext key if it is neither
+// revoked nor expired, and records its use.
+func (s *APIKeyStore) Authenticate(ctx context.Context, plaintext string) (*models.APIKey, error) {
+	hash := HashAPIKey(plaintext)
+	now := time.Now()
+
+	s.mu.Lock()
+	cached, ok := s.cache[hash]
+	s.mu.Unlock()
+
+	if !ok || now.After(cached.expires) {
+		key, err := s.load(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, ErrAPIKeyInvalid
+		}
+		cached = &cachedAPIKey{key: key, expires: now.Add(s.ttl)}
+
+		s.mu.Lock()
+		if len(s.cache) >= accountCacheSweep {
+			for h, c := range s.cache {
+				if now.After(c.expires) {
+					delete(s.cache, h)
+				}
+			}
+		}
+		s.cache[hash] = cached
+		s.mu.Unlock()
+	}
+
+	key := cached.key
+	if key.RevokedAt != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	s.mu.Lock()
+	touch := now.Sub(cached.touched) >= apiKeyTouchInterval
+	if touch {
+		cached.touched = now
+	}
+	s.mu.Unlock()
+	if touch {
+		go s.touch(key.ID)
+	}
+
+	return key, nil
+}
+
+// Invalidate drops cached lookups of the key so that changes take effect
+// immediately on this replica.
+func (s *APIKeyStore) Invalidate(keyID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, c := range s.cache {
+		if c.key.ID == keyID {
+			delete(s.cache, hash)
+		}
+	}
+}
+
+// load returns the key with hash, or nil if there is none.
+func (s *APIKeyStore) load(ctx context.Context, hash string) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes []string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, rate_limit, expires_at, last_used_at,
+		       revoked_at, created_by, created_at, updated_at
+		FROM api_keys WHERE key_hash = $1
+	`, hash).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&scopes), &key.RateLimit,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedBy, &key.CreatedAt, &key.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, models.APIKeyScope(scope))
+	}
+	return key, nil
+}
+
+func (s *APIKeyStore) touch(keyID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, keyID)
+	if err != nil {
+		log.Printf("Failed to record API key use: %v", err)
+	}
+}
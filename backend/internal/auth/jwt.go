@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
 )
 
 var (
@@ -13,9 +14,24 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+const (
+	// ChallengeExpiry is how long a user has to enter their TOTP code after
+	// the password step.
+	ChallengeExpiry = 5 * time.Minute
+
+	purposeChallenge = "2fa_challenge"
+)
+
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
+	UserID uuid.UUID       `json:"user_id"`
+	Email  string          `json:"email"`
+	Role   models.UserRole `json:"role"`
+	// TwoFactor is set when the login completed a TOTP or recovery code
+	// step.
+	TwoFactor bool `json:"2fa,omitempty"`
+	// Purpose marks restricted tokens, such as login challenges, that must
+	// not be accepted as access tokens.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -23,20 +39,26 @@ type JWTManager struct {
 	secretKey     string
 	tokenExpiry   time.Duration
 	refreshExpiry time.Duration
+	accounts      *AccountStore
 }
 
-func NewJWTManager(secretKey string, tokenExpiry, refreshExpiry time.Duration) *JWTManager {
+// NewJWTManager creates a token manager. When accounts is set,
+// AuthMiddleware also enforces account states.
+func NewJWTManager(secretKey string, tokenExpiry, refreshExpiry time.Duration, accounts *AccountStore) *JWTManager {
 	return &JWTManager{
 		secretKey:     secretKey,
 		tokenExpiry:   tokenExpiry,
 		refreshExpiry: refreshExpiry,
+		accounts:      accounts,
 	}
 }
 
-func (m *JWTManager) GenerateToken(userID uuid.UUID, email string) (string, error) {
+func (m *JWTManager) GenerateToken(userID uuid.UUID, email string, role models.UserRole, twoFactor bool) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		TwoFactor: twoFactor,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.tokenExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -48,10 +70,12 @@ func (m *JWTManager) GenerateToken(userID uuid.UUID, email string) (string, erro
 	return token.SignedString([]byte(m.secretKey))
 }
 
-func (m *JWTManager) GenerateRefreshToken(userID uuid.UUID, email string) (string, error) {
+func (m *JWTManager) GenerateRefreshToken(userID uuid.UUID, email string, role models.UserRole, twoFactor bool) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		TwoFactor: twoFactor,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.refreshExpiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -62,7 +86,49 @@ func (m *JWTManager) GenerateRefreshToken(userID uuid.UUID, email string) (strin
 	return token.SignedString([]byte(m.secretKey))
 }
 
+// GenerateChallengeToken issues the short-lived token that carries a login
+// from the password step to the TOTP step.
+func (m *JWTManager) GenerateChallengeToken(userID uuid.UUID, email string, role models.UserRole) (string, error) {
+	claims := &Claims{
+		UserID:  userID,
+		Email:   email,
+		Role:    role,
+		Purpose: purposeChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ChallengeExpiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(m.secretKey))
+}
+
+// ValidateChallengeToken accepts only tokens from GenerateChallengeToken.
+func (m *JWTManager) ValidateChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeChallenge {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ValidateToken accepts access and refresh tokens, refusing restricted ones.
 func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := m.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (m *JWTManager) parse(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, ErrInvalidToken
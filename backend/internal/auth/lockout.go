@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// FailureWindow is how long failed logins are remembered after the last
+	// one.
+	FailureWindow = time.Hour
+
+	// Failures past the free attempts must wait BaseBackoff, doubling with
+	// each further failure up to MaxBackoff. Addresses get more free
+	// attempts than accounts because many users can share one.
+	AccountFreeAttempts = 3
+	IPFreeAttempts      = 20
+	BaseBackoff         = time.Second
+	MaxBackoff          = 5 * time.Minute
+
+	// LockoutThreshold failures lock an account for LockoutDuration, or until
+	// the owner follows the unlock link sent by email.
+	LockoutThreshold = 10
+	LockoutDuration  = 30 * time.Minute
+
+	lockoutPrefix = "login:"
+)
+
+// LoginBlock says why a login may not be attempted right now.
+type LoginBlock struct {
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// LoginGuard tracks failed logins per account and per client address in
+// Redis. Accounts are keyed by email, whether or not it exists, so that
+// responses do not reveal which emails are registered.
+//
+// Every attempt is counted as a failure when it is let through and given
+// back if it succeeds, so that concurrent attempts cannot all pass the same
+// backoff check before any of them has failed.
+type LoginGuard struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+func NewLoginGuard(client *redis.Client) *LoginGuard {
+	return &LoginGuard{client: client, now: time.Now}
+}
+
+// attemptScript returns {1, ttl} if the account is locked, {0, wait} if the
+// account or the address is still backing off, and otherwise counts the
+// attempt against both and returns {0, 0}.
+var attemptScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local locked = redis.call('PTTL', KEYS[1])
+if locked > 0 then
+	return {1, locked}
+end
+
+local function wait(key, free)
+	local values = redis.call('HMGET', key, 'count', 'last')
+	local count = tonumber(values[1]) or 0
+	local last = tonumber(values[2]) or 0
+	if count <= free then
+		return 0
+	end
+	local delay = tonumber(ARGV[5])
+	local shift = count - free - 1
+	if shift < 20 then
+		delay = math.min(tonumber(ARGV[4]) * 2 ^ shift, delay)
+	end
+	return math.max(last + delay - now, 0)
+end
+
+local w = math.max(wait(KEYS[2], tonumber(ARGV[2])), wait(KEYS[3], tonumber(ARGV[3])))
+if w > 0 then
+	return {0, w}
+end
+
+for i = 2, 3 do
+	redis.call('HINCRBY', KEYS[i], 'count', 1)
+	redis.call('HSET', KEYS[i], 'last', now)
+	redis.call('PEXPIRE', KEYS[i], ARGV[6])
+end
+return {0, 0}
+`)
+
+// releaseScript gives back one counted attempt on each key that has one.
+var releaseScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	local count = tonumber(redis.call('HGET', key, 'count'))
+	if count and count > 0 then
+		redis.call('HINCRBY', key, 'count', -1)
+	end
+end
+return 0
+`)
+
+// Attempt returns a block if the account is locked or either the account or
+// the address is still backing off. A nil block means the attempt may go
+// ahead; it is then counted as failed until Succeed or Release gives it
+// back.
+func (g *LoginGuard) Attempt(ctx context.Context, email, ip string) (*LoginBlock, error) {
+	account := accountKey(email)
+	keys := []string{lockoutPrefix + "lock:" + account, lockoutPrefix + "fail:acct:" + account, lockoutPrefix + "fail:ip:" + ip}
+	result, err := attemptScript.Run(ctx, g.client, keys,
+		g.now().UnixMilli(), AccountFreeAttempts, IPFreeAttempts,
+		BaseBackoff.Milliseconds(), MaxBackoff.Milliseconds(), FailureWindow.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	wait := time.Duration(result[1]) * time.Millisecond
+	if result[0] == 1 {
+		return &LoginBlock{Locked: true, RetryAfter: wait}, nil
+	}
+	if wait > 0 {
+		return &LoginBlock{RetryAfter: wait}, nil
+	}
+	return nil, nil
+}
+
+// Fail marks the attempt as failed; it was counted as such already. locked
+// reports whether this failure locked the account.
+func (g *LoginGuard) Fail(ctx context.Context, email string) (locked bool, err error) {
+	account := accountKey(email)
+
+	count, err := g.client.HGet(ctx, lockoutPrefix+"fail:acct:"+account, "count").Int64()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if count < LockoutThreshold {
+		return false, nil
+	}
+	return g.client.SetNX(ctx, lockoutPrefix+"lock:"+account, 1, LockoutDuration).Result()
+}
+
+// Release gives back an attempt whose first factor was right, while the
+// login waits for the second.
+func (g *LoginGuard) Release(ctx context.Context, email, ip string) error {
+	keys := []string{lockoutPrefix + "fail:acct:" + accountKey(email), lockoutPrefix + "fail:ip:" + ip}
+	return releaseScript.Run(ctx, g.client, keys).Err()
+}
+
+// Succeed forgets the account's failures after a successful login, and
+// gives back the address's attempt.
+func (g *LoginGuard) Succeed(ctx context.Context, email, ip string) error {
+	if err := g.client.Del(ctx, lockoutPrefix+"fail:acct:"+accountKey(email)).Err(); err != nil {
+		return err
+	}
+	return releaseScript.Run(ctx, g.client, []string{lockoutPrefix + "fail:ip:" + ip}).Err()
+}
+
+// IssueUnlockToken creates a single-use token that lifts the account's
+// lockout. It expires with the lockout.
+func (g *LoginGuard) IssueUnlockToken(ctx context.Context, email string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := g.client.Set(ctx, lockoutPrefix+"unlock:"+hashToken(token), accountKey(email), LockoutDuration).Err()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Unlock redeems an unlock token, clearing the lockout and the account's
+// failures. It reports false for unknown or expired tokens.
+func (g *LoginGuard) Unlock(ctx context.Context, token string) (bool, error) {
+	account, err := g.client.GetDel(ctx, lockoutPrefix+"unlock:"+hashToken(token)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = g.client.Del(ctx, lockoutPrefix+"lock:"+account, lockoutPrefix+"fail:acct:"+account).Err()
+	return err == nil, err
+}
+
+// accountKey keys an account by its normalised email without putting the
+// address itself into Redis.
+func accountKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
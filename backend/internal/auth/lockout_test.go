@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestGuard returns a guard on an in-memory Redis whose clock only moves
+// when the test advances it.
+func newTestGuard(t *testing.T) (*LoginGuard, *miniredis.Miniredis, func(time.Duration)) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	guard := NewLoginGuard(client)
+	guard.now = func() time.Time { return now }
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		mr.FastForward(d)
+	}
+	return guard, mr, advance
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	ctx := context.Background()
+	guard, _, advance := newTestGuard(t)
+
+	// Each step is an attempt after waiting, and whether it is let through
+	tests := []struct {
+		wait      time.Duration
+		wantRetry time.Duration
+	}{
+		{0, 0},
+		{0, 0},
+		{0, 0},
+		{0, 0},
+		// Four failures, one past the free attempts: wait a second
+		{0, BaseBackoff},
+		{BaseBackoff / 2, BaseBackoff / 2},
+		{BaseBackoff / 2, 0},
+		// Then two, four, ...
+		{0, 2 * BaseBackoff},
+		{2 * BaseBackoff, 0},
+		{BaseBackoff, 3 * BaseBackoff},
+	}
+	for i, tt := range tests {
+		advance(tt.wait)
+		block, err := guard.Attempt(ctx, "driver@example.com", "203.0.113.7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var retry time.Duration
+		if block != nil {
+			if block.Locked {
+				t.Fatalf("attempt %d: locked", i+1)
+			}
+			retry = block.RetryAfter
+		}
+		if retry != tt.wantRetry {
+			t.Fatalf("attempt %d: retry after %v, want %v", i+1, retry, tt.wantRetry)
+		}
+		if block == nil {
+			if _, err := guard.Fail(ctx, "driver@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestLoginGuardConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	guard, _, _ := newTestGuard(t)
+
+	// Use up the free attempts
+	for i := 0; i < AccountFreeAttempts; i++ {
+		if block, err := guard.Attempt(ctx, "driver@example.com", "203.0.113.7"); err != nil || block != nil {
+			t.Fatalf("free attempt %d: %+v, %v", i+1, block, err)
+		}
+	}
+
+	// Of many guesses racing for the next slot, only one gets through
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			block, err := guard.Attempt(ctx, "driver@example.com", "203.0.113.7")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if block == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Errorf("%d concurrent attempts let through, want 1", allowed)
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	ctx := context.Background()
+	guard, _, advance := newTestGuard(t)
+
+	for i := 1; i <= LockoutThreshold; i++ {
+		advance(MaxBackoff)
+		block, err := guard.Attempt(ctx, "driver@example.com", "203.0.113.7")
+		if err != nil || block != nil {
+			t.Fatalf("attempt %d: %+v, %v", i, block, err)
+		}
+		locked, err := guard.Fail(ctx, "driver@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != (i == LockoutThreshold) {
+			t.Fatalf("failure %d locked = %v", i, locked)
+		}
+	}
+
+	advance(MaxBackoff)
+	block, err := guard.Attempt(ctx, "Driver@Example.com ", "198.51.100.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block == nil || !block.Locked || block.RetryAfter != LockoutDuration-MaxBackoff {
+		t.Fatalf("attempt while locked = %+v, want locked for %v", block, LockoutDuration-MaxBackoff)
+	}
+
+	token, err := guard.IssueUnlockToken(ctx, "driver@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := guard.Unlock(ctx, token); !ok || err != nil {
+		t.Fatalf("Unlock() = %v, %v", ok, err)
+	}
+	if ok, _ := guard.Unlock(ctx, token); ok {
+		t.Error("unlock token worked twice")
+	}
+	if block, err := guard.Attempt(ctx, "driver@example.com", "198.51.100.1"); err != nil || block != nil {
+		t.Errorf("attempt after unlock = %+v, %v", block, err)
+	}
+}
+
+func TestLoginGuardSucceedAndRelease(t *testing.T) {
+	ctx := context.Background()
+	guard, mr, _ := newTestGuard(t)
+	ip := "203.0.113.7"
+
+	tests := []struct {
+		name     string
+		finish   func(email string) error
+		wantAcct string
+		wantIP   string
+	}{
+		{"success forgets the account", func(email string) error { return guard.Succeed(ctx, email, ip) }, "", "0"},
+		{"release gives the attempt back", func(email string) error { return guard.Release(ctx, email, ip) }, "0", "0"},
+		{"failure keeps it", func(email string) error { _, err := guard.Fail(ctx, email); return err }, "1", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr.FlushAll()
+			email := "driver@example.com"
+			if block, err := guard.Attempt(ctx, email, ip); err != nil || block != nil {
+				t.Fatalf("Attempt() = %+v, %v", block, err)
+			}
+			if err := tt.finish(email); err != nil {
+				t.Fatal(err)
+			}
+
+			acct := mr.HGet(lockoutPrefix+"fail:acct:"+accountKey(email), "count")
+			if acct != tt.wantAcct {
+				t.Errorf("account count = %q, want %q", acct, tt.wantAcct)
+			}
+			if got := mr.HGet(lockoutPrefix+"fail:ip:"+ip, "count"); got != tt.wantIP {
+				t.Errorf("address count = %q, want %q", got, tt.wantIP)
+			}
+		})
+	}
+
+	// Releasing without an attempt does not go below zero
+	mr.FlushAll()
+	if err := guard.Release(ctx, "nobody@example.com", ip); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(lockoutPrefix + "fail:ip:" + ip) {
+		t.Error("release created a counter")
+	}
+}
@@ -2,10 +2,13 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
 )
 
 type contextKey string
@@ -13,6 +16,7 @@ type contextKey string
 const (
 	UserIDKey contextKey = "user_id"
 	EmailKey  contextKey = "email"
+	RoleKey   contextKey = "role"
 )
 
 func (m *JWTManager) AuthMiddleware(next http.Handler) http.Handler {
@@ -36,13 +40,52 @@ func (m *JWTManager) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-		ctx = context.WithValue(ctx, EmailKey, claims.Email)
+		if m.accounts != nil && !m.allowAccount(w, r, claims.UserID) {
+			return
+		}
+
+		// Privileged roles only take effect after a two-factor login
+		role := claims.Role
+		if role.RequiresTwoFactor() && !claims.TwoFactor {
+			role = models.UserRoleUser
+		}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
+		principal := &Principal{UserID: claims.UserID, Email: claims.Email, Role: role}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
 	})
 }
 
+// allowAccount enforces the caller's account state, writing the error
+// response when the request is refused. Banned accounts are refused
+// outright; suspended ones keep read-only access.
+func (m *JWTManager) allowAccount(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	account, err := m.accounts.Get(r.Context(), userID)
+	if errors.Is(err, ErrAccountNotFound) {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load account", http.StatusInternalServerError)
+		return false
+	}
+
+	switch account.Effective(time.Now()) {
+	case models.AccountStatusBanned:
+		http.Error(w, "Account is banned", http.StatusForbidden)
+		return false
+	case models.AccountStatusSuspended:
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			msg := "Account is suspended"
+			if account.SuspendedUntil != nil {
+				msg += " until " + account.SuspendedUntil.UTC().Format(time.RFC3339)
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
 func GetUserIDFromContext(ctx context.Context) (uuid.UUID, bool) {
 	userID, ok := ctx.Value(UserIDKey).(uuid.UUID)
 	return userID, ok
@@ -52,3 +95,30 @@ func GetEmailFromContext(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(EmailKey).(string)
 	return email, ok
 }
+
+// GetRoleFromContext returns the caller's role, defaulting to a regular user
+// for tokens issued before roles existed.
+func GetRoleFromContext(ctx context.Context) models.UserRole {
+	role, ok := ctx.Value(RoleKey).(models.UserRole)
+	if !ok || role == "" {
+		return models.UserRoleUser
+	}
+	return role
+}
+
+// RequireRole only lets through callers holding one of the given roles. It
+// must run after AuthMiddleware.
+func RequireRole(roles ...models.UserRole) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := GetRoleFromContext(r.Context())
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// OIDCSessionTTL bounds how long a browser sign-in may take between
+	// authorize and callback.
+	OIDCSessionTTL = 10 * time.Minute
+
+	// Signing keys are refetched after jwksTTL, or sooner when a token names
+	// an unknown key, but no more than once per jwksMinRefresh.
+	jwksTTL        = time.Hour
+	jwksMinRefresh = time.Minute
+
+	oidcSessionPrefix = "oidc:state:"
+	oidcNoncePrefix   = "oidc:nonce:"
+)
+
+var (
+	ErrInvalidIDToken     = errors.New("invalid ID token")
+	ErrOIDCSessionUnknown = errors.New("unknown or expired sign-in session")
+	ErrIDTokenReplayed    = errors.New("ID token nonce already used")
+)
+
+// OIDCConfig describes an OpenID Connect provider. Endpoints come from the
+// issuer's discovery document; JWKSURL overrides the one found there.
+type OIDCConfig struct {
+	Name   string
+	Issuer string
+	// ClientIDs are the audiences accepted in ID tokens. Native apps usually
+	// have their own client IDs; the first one is used for browser sign-in.
+	ClientIDs    []string
+	ClientSecret string
+	JWKSURL      string
+	RedirectURL  string
+}
+
+// OIDCIdentity is the verified subject of an ID token.
+type OIDCIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// ExpiresAt is when the ID token expires.
+	ExpiresAt time.Time
+}
+
+type oidcDiscovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with one OpenID Connect provider using the
+// authorization code flow with PKCE, or by verifying ID tokens obtained by
+// native apps.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewOIDCProvider(cfg OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL returns the provider URL to send the browser to.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientIDs[0])
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", "openid email")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientIDs[0])
+	form.Set("code_verifier", verifier)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%s token endpoint: %w", p.cfg.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("%s token endpoint: status %d %s", p.cfg.Name, resp.StatusCode, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Nonce         string       `json:"nonce"`
+}
+
+// flexibleBool accepts both true and "true"; Apple sends the latter.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = flexibleBool(s == "true")
+	return nil
+}
+
+// VerifyIDToken checks the token's signature, issuer, audience, expiry and
+// nonce, which must be given.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256", "ES256"}), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// Google tokens may name the issuer without its scheme
+	if claims.Issuer != p.cfg.Issuer && "https://"+claims.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !p.acceptsAudience(claims.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &OIDCIdentity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		ExpiresAt:     claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (p *OIDCProvider) acceptsAudience(audience jwt.ClaimStrings) bool {
+	for _, aud := range audience {
+		for _, id := range p.cfg.ClientIDs {
+			if aud == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the signing key kid, refetching the key set when it is stale
+// or does not contain kid.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetched) > jwksTTL
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && time.Since(p.keysFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys loads the provider's RSA and P-256 signing keys. Called with
+// p.mu held.
+func (p *OIDCProvider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := p.cfg.JWKSURL
+	if jwksURL == "" {
+		d, err := p.discoverLocked(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jwksURL = d.JWKSURI
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discoverLocked(ctx)
+}
+
+func (p *OIDCProvider) discoverLocked(ctx context.Context) (*oidcDiscovery, error) {
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &oidcDiscovery{}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	p.discovery = d
+	return d, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// OIDCSession is what a browser sign-in must remember between authorize and
+// callback.
+type OIDCSession struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// OIDCSessions keeps browser sign-in sessions in Redis, keyed by state.
+type OIDCSessions struct {
+	client *redis.Client
+}
+
+func NewOIDCSessions(client *redis.Client) *OIDCSessions {
+	return &OIDCSessions{client: client}
+}
+
+// Start creates a session for provider and returns its state, nonce and
+// PKCE verifier.
+func (s *OIDCSessions) Start(ctx context.Context, provider string) (state string, session *OIDCSession, err error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	state = values[0]
+	session = &OIDCSession{Provider: provider, Nonce: values[1], Verifier: values[2]}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.client.Set(ctx, oidcSessionPrefix+hashToken(state), data, OIDCSessionTTL).Err(); err != nil {
+		return "", nil, err
+	}
+	return state, session, nil
+}
+
+// Take returns and removes the session for state, so each can complete
+// only one sign-in.
+func (s *OIDCSessions) Take(ctx context.Context, state string) (*OIDCSession, error) {
+	data, err := s.client.GetDel(ctx, oidcSessionPrefix+hashToken(state)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrOIDCSessionUnknown
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := &OIDCSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// UseNonce records that an ID token with nonce signed someone in, until the
+// token expires. It returns ErrIDTokenReplayed if the nonce was used before,
+// so that a captured ID token cannot be posted again.
+func (s *OIDCSessions) UseNonce(ctx context.Context, provider, nonce string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	first, err := s.client.SetNX(ctx, oidcNoncePrefix+provider+":"+hashToken(nonce), 1, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrIDTokenReplayed
+	}
+	return nil
+}
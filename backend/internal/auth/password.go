@@ -1,17 +1,212 @@
 package auth
 
 import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-const bcryptCost = 12
+const (
+	// MaxPasswordLength bounds the work a single login can cause.
+	MaxPasswordLength = 256
+
+	argon2idPrefix = "$argon2id$"
+
+	// legacyBcryptCost is the cost of the bcrypt hashes stored before
+	// argon2id.
+	legacyBcryptCost = 12
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordCommon   = errors.New("password is too common")
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// The hash functions, replaced in tests to count the work done.
+var (
+	argon2IDKey           = argon2.IDKey
+	compareBcryptPassword = bcrypt.CompareHashAndPassword
+)
+
+// Argon2Params are the argon2id cost parameters for new hashes. Memory is in
+// KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// PasswordPolicy is checked when a password is chosen.
+type PasswordPolicy struct {
+	MinLength int
+	// RejectCommon refuses passwords on the bundled list of breached and
+	// common passwords.
+	RejectCommon bool
+}
+
+// PasswordManager hashes passwords with argon2id. Hashes are stored in PHC
+// string format so their algorithm and parameters travel with them; older
+// bcrypt hashes still verify and are flagged for rehashing.
+type PasswordManager struct {
+	params Argon2Params
+	policy PasswordPolicy
+	common map[string]struct{}
+
+	dummyOnce   sync.Once
+	dummyArgon2 string
+	dummyBcrypt []byte
+}
+
+func NewPasswordManager(params Argon2Params, policy PasswordPolicy) *PasswordManager {
+	m := &PasswordManager{params: params, policy: policy}
+	if policy.RejectCommon {
+		m.common = make(map[string]struct{})
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				m.common[strings.ToLower(line)] = struct{}{}
+			}
+		}
+	}
+	return m
+}
+
+func (m *PasswordManager) Policy() PasswordPolicy {
+	return m.policy
+}
+
+// Validate checks password against the policy.
+func (m *PasswordManager) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < m.policy.MinLength {
+		return fmt.Errorf("%w, use at least %d characters", ErrPasswordTooShort, m.policy.MinLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if _, ok := m.common[strings.ToLower(password)]; ok {
+		return ErrPasswordCommon
+	}
+	return nil
+}
+
+func (m *PasswordManager) Hash(password string) (string, error) {
+	salt := make([]byte, m.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := m.params
+	key := argon2IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against hash. rehash reports that the password was
+// correct but hash should be replaced with Hash(password), because it uses
+// bcrypt or outdated parameters.
+//
+// Every check costs one argon2id and one bcrypt comparison, real or dummy,
+// so that response times do not tell unknown emails, users without a
+// password and users with a legacy hash apart.
+func (m *PasswordManager) Verify(password, hash string) (ok, rehash bool) {
+	if len(password) > MaxPasswordLength {
+		return false, false
+	}
+	m.dummyOnce.Do(func() {
+		m.dummyArgon2, _ = m.Hash("roadeye-dummy-password")
+		m.dummyBcrypt, _ = bcrypt.GenerateFromPassword([]byte("roadeye-dummy-password"), legacyBcryptCost)
+	})
+
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		compareBcryptPassword(m.dummyBcrypt, []byte(password))
+		return m.verifyArgon2id(password, hash)
+	}
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
-	return string(bytes), err
+	m.verifyArgon2id(password, m.dummyArgon2)
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		// Empty for users without a password
+		compareBcryptPassword(m.dummyBcrypt, []byte(password))
+		return false, false
+	}
+	if compareBcryptPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	return true, true
 }
 
-func CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// Reject takes as long as Verify but always fails. Logins for unknown emails
+// call it so that response times do not reveal which accounts exist.
+func (m *PasswordManager) Reject(password string) bool {
+	m.Verify(password, "")
+	return false
+}
+
+func (m *PasswordManager) verifyArgon2id(password, hash string) (ok, rehash bool) {
+	p, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		m.verifyArgon2id(password, m.dummyArgon2)
+		return false, false
+	}
+	computed := argon2IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false
+	}
+
+	current := m.params
+	rehash = p.Memory != current.Memory || p.Iterations != current.Iterations ||
+		p.Parallelism != current.Parallelism || p.KeyLength != current.KeyLength
+	return true, rehash
+}
+
+func decodeArgon2idHash(hash string) (p Argon2Params, salt, key []byte, err error) {
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, err
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, err
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
 }
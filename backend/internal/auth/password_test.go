@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep hashing fast in tests.
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestDecodeArgon2idHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		want    Argon2Params
+		wantErr bool
+	}{
+		{
+			name: "current format",
+			hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+			want: Argon2Params{Memory: 65536, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 29},
+		},
+		{name: "too few fields", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA", wantErr: true},
+		{name: "old version", hash: "$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$a2V5", wantErr: true},
+		{name: "bad parameters", hash: "$argon2id$v=19$m=lots,t=3,p=2$c2FsdA$a2V5", wantErr: true},
+		{name: "padded salt", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA==$a2V5", wantErr: true},
+		{name: "bad key", hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$!!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, err := decodeArgon2idHash(tt.hash)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeArgon2idHash() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeArgon2idHash() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decodeArgon2idHash() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordVerify(t *testing.T) {
+	m := NewPasswordManager(testArgon2Params, PasswordPolicy{})
+
+	current, err := m.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Hash() = %q, not in PHC format with the configured parameters", current)
+	}
+
+	weaker := testArgon2Params
+	weaker.Iterations = 2
+	outdated, err := NewPasswordManager(weaker, PasswordPolicy{}).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"current hash", "correct horse", current, true, false},
+		{"wrong password", "wrong horse", current, false, false},
+		{"outdated parameters", "correct horse", outdated, true, true},
+		{"outdated parameters, wrong password", "wrong horse", outdated, false, false},
+		{"bcrypt", "correct horse", string(legacy), true, true},
+		{"bcrypt, wrong password", "wrong horse", string(legacy), false, false},
+		{"no password set", "", "", false, false},
+		{"malformed hash", "correct horse", "$argon2id$v=19$broken", false, false},
+		{"too long", strings.Repeat("a", MaxPasswordLength+1), current, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := m.Verify(tt.password, tt.hash)
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestPasswordValidate(t *testing.T) {
+	m := NewPasswordManager(testArgon2Params, PasswordPolicy{MinLength: 8, RejectCommon: true})
+
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"a sensible passphrase", nil},
+		{"short", ErrPasswordTooShort},
+		{"ééééééé", ErrPasswordTooShort},
+		{strings.Repeat("a", MaxPasswordLength+1), ErrPasswordTooLong},
+		{"password", ErrPasswordCommon},
+		{"PASSWORD", ErrPasswordCommon},
+	}
+	for _, tt := range tests {
+		if err := m.Validate(tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.password, err, tt.want)
+		}
+	}
+}
+
+func TestPasswordVerifyWork(t *testing.T) {
+	m := NewPasswordManager(testArgon2Params, PasswordPolicy{})
+	current, err := m.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Reject("warm up the dummy hashes")
+
+	var argon2Runs, bcryptRuns int
+	realIDKey, realCompare := argon2IDKey, compareBcryptPassword
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		argon2Runs++
+		return realIDKey(password, salt, time, memory, threads, keyLen)
+	}
+	compareBcryptPassword = func(hash, password []byte) error {
+		bcryptRuns++
+		return realCompare(hash, password)
+	}
+	t.Cleanup(func() { argon2IDKey, compareBcryptPassword = realIDKey, realCompare })
+
+	tests := []struct {
+		name  string
+		check func()
+	}{
+		{"argon2id hash", func() { m.Verify("x", current) }},
+		{"bcrypt hash", func() { m.Verify("x", string(legacy)) }},
+		{"no password set", func() { m.Verify("x", "") }},
+		{"malformed argon2id hash", func() { m.Verify("x", "$argon2id$v=19$broken") }},
+		{"unrecognised hash", func() { m.Verify("x", "plaintext") }},
+		{"unknown email", func() { m.Reject("x") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argon2Runs, bcryptRuns = 0, 0
+			tt.check()
+			if argon2Runs != 1 || bcryptRuns != 1 {
+				t.Errorf("ran argon2id %d and bcrypt %d times, want once each", argon2Runs, bcryptRuns)
+			}
+		})
+	}
+}
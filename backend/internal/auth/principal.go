@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const PrincipalKey contextKey = "principal"
+
+// Principal is the authenticated caller, whether a user with a JWT or a
+// partner with an API key. API key requests act as the key's owner with
+// the privileges of a regular user, limited to the key's scopes.
+type Principal struct {
+	UserID uuid.UUID
+	Email  string
+	Role   models.UserRole
+	// APIKey is set when the request was authenticated with an API key.
+	APIKey *models.APIKey
+}
+
+// HasScope reports whether the principal may use scope. Users hold every
+// scope.
+func (p *Principal) HasScope(scope models.APIKeyScope) bool {
+	return p.APIKey == nil || p.APIKey.HasScope(scope)
+}
+
+func GetPrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(PrincipalKey).(*Principal)
+	return principal, ok
+}
+
+// withPrincipal stores the principal, along with the individual values
+// read by the older helpers.
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	ctx = context.WithValue(ctx, PrincipalKey, p)
+	ctx = context.WithValue(ctx, UserIDKey, p.UserID)
+	ctx = context.WithValue(ctx, EmailKey, p.Email)
+	return context.WithValue(ctx, RoleKey, p.Role)
+}
+
+// Authenticator accepts either a JWT bearer token or an X-API-Key header.
+type Authenticator struct {
+	jwt  *JWTManager
+	keys *APIKeyStore
+}
+
+func NewAuthenticator(jwt *JWTManager, keys *APIKeyStore) *Authenticator {
+	return &Authenticator{jwt: jwt, keys: keys}
+}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	jwtAuth := a.jwt.AuthMiddleware(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext := r.Header.Get(HeaderAPIKey)
+		if plaintext == "" {
+			jwtAuth.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := a.keys.Authenticate(r.Context(), plaintext)
+		if errors.Is(err, ErrAPIKeyExpired) {
+			http.Error(w, "API key has expired", http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrAPIKeyInvalid) {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to verify API key", http.StatusInternalServerError)
+			return
+		}
+
+		// Keys are subject to their owner's account state
+		if a.jwt.accounts != nil && !a.jwt.allowAccount(w, r, key.UserID) {
+			return
+		}
+
+		principal := &Principal{UserID: key.UserID, Role: models.UserRoleUser, APIKey: key}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+	})
+}
+
+// RequireScope lets through users and API keys holding scope. It must run
+// after Authenticator.Middleware.
+func RequireScope(scope models.APIKeyScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := GetPrincipalFromContext(r.Context())
+			if !ok || !principal.HasScope(scope) {
+				http.Error(w, "API key lacks the "+string(scope)+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireUser refuses API keys, for routes only users may call. It must
+// run after Authenticator.Middleware.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := GetPrincipalFromContext(r.Context())
+		if !ok || principal.APIKey != nil {
+			http.Error(w, "Not available with an API key", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
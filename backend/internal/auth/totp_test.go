@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := base32NoPad.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The RFC gives eight digits; six-digit codes are their last six.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step := tt.unix / int64(TOTPPeriod.Seconds())
+		if got := totpCode(key, step); got != tt.want {
+			t.Errorf("totpCode at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / int64(TOTPPeriod.Seconds())
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, "050471", now, step, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", now, step, true},
+		{"one step behind", rfc6238Secret, "050471", now.Add(TOTPPeriod), step, true},
+		{"one step ahead", rfc6238Secret, "050471", now.Add(-TOTPPeriod), step, true},
+		{"two steps behind", rfc6238Secret, "050471", now.Add(2 * TOTPPeriod), 0, false},
+		{"two steps ahead", rfc6238Secret, "050471", now.Add(-2 * TOTPPeriod), 0, false},
+		{"wrong code", rfc6238Secret, "123456", now, 0, false},
+		{"too short", rfc6238Secret, "05047", now, 0, false},
+		{"too long", rfc6238Secret, "0504710", now, 0, false},
+		{"bad secret", "not base32!", "050471", now, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxx-xxxx-xxxx-xxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q repeated", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcd-efgh-2345-67ab")
+
+	tests := []struct {
+		code string
+		same bool
+	}{
+		{"abcd-efgh-2345-67ab", true},
+		{"ABCD-EFGH-2345-67AB", true},
+		{"abcdefgh234567ab", true},
+		{"  abcd-efgh-2345-67ab\n", true},
+		{"abcd-efgh-2345-67ac", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := HashRecoveryCode(tt.code) == want; got != tt.same {
+			t.Errorf("HashRecoveryCode(%q) matches = %v, want %v", tt.code, got, tt.same)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package comments
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var ErrContainsURL = errors.New("comments may not contain links")
+
+// Filter inspects a comment body before it is stored. It may return a
+// rewritten body, or an error to reject the comment outright.
+type Filter interface {
+	Filter(body string) (string, error)
+}
+
+// Chain runs filters in order, feeding each the previous one's output.
+type Chain []Filter
+
+func (c Chain) Filter(body string) (string, error) {
+	for _, f := range c {
+		var err error
+		if body, err = f.Filter(body); err != nil {
+			return "", err
+		}
+	}
+	return body, nil
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|co|info|biz|xyz|ly)(/\S*)?\b`)
+
+// URLFilter rejects comments containing links, the usual payload of spam.
+type URLFilter struct{}
+
+func (URLFilter) Filter(body string) (string, error) {
+	if urlPattern.MatchString(body) {
+		return "", ErrContainsURL
+	}
+	return body, nil
+}
+
+// ProfanityFilter masks blocked words with asterisks, matching whole words
+// case-insensitively.
+type ProfanityFilter struct {
+	words map[string]struct{}
+}
+
+func NewProfanityFilter(words []string) *ProfanityFilter {
+	f := &ProfanityFilter{words: make(map[string]struct{}, len(words))}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words[w] = struct{}{}
+		}
+	}
+	return f
+}
+
+func (f *ProfanityFilter) Filter(body string) (string, error) {
+	if len(f.words) == 0 {
+		return body, nil
+	}
+
+	runes := []rune(body)
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		inWord := i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+		if inWord && start < 0 {
+			start = i
+		}
+		if !inWord && start >= 0 {
+			if _, blocked := f.words[strings.ToLower(string(runes[start:i]))]; blocked {
+				for j := start; j < i; j++ {
+					runes[j] = '*'
+				}
+			}
+			start = -1
+		}
+	}
+
+	return string(runes), nil
+}
+
+// DefaultBlocklist seeds the profanity filter when no list is configured.
+var DefaultBlocklist = []string{
+	"fuck", "fucking", "shit", "bitch", "asshole", "bastard", "cunt", "dick", "motherfucker",
+}
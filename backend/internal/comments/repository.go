@@ -0,0 +1,183 @@
+package comments
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/pagination"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+	MaxBodyLen   = 1000
+)
+
+var (
+	ErrNotFound        = errors.New("comment not found")
+	ErrInvalidCursor   = pagination.ErrInvalidCursor
+	ErrAlreadyReported = errors.New("comment already reported")
+)
+
+const commentColumns = `c.id, c.hazard_id, c.user_id, u.username, c.body, c.edited, c.created_at, c.updated_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func scanComment(row interface{ Scan(...interface{}) error }) (*models.Comment, error) {
+	comment := &models.Comment{}
+	err := row.Scan(
+		&comment.ID, &comment.HazardID, &comment.UserID, &comment.Username,
+		&comment.Body, &comment.Edited, &comment.CreatedAt, &comment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+func (r *Repository) Create(ctx context.Context, comment *models.Comment) error {
+	query := `
+		WITH inserted AS (
+			INSERT INTO hazard_comments (id, hazard_id, user_id, body)
+			VALUES ($1, $2, $3, $4)
+			RETURNING created_at, updated_at, user_id
+		)
+		SELECT i.created_at, i.updated_at, u.username, u.status = 'shadow_banned'
+		FROM inserted i JOIN users u ON u.id = i.user_id
+	`
+
+	return r.db.QueryRowContext(ctx, query, comment.ID, comment.HazardID, comment.UserID, comment.Body).
+		Scan(&comment.CreatedAt, &comment.UpdatedAt, &comment.Username, &comment.Shadowed)
+}
+
+func (r *Repository) GetByID(ctx context.Context, hazardID, id uuid.UUID) (*models.Comment, error) {
+	query := `
+		SELECT ` + commentColumns + `
+		FROM hazard_comments c JOIN users u ON u.id = c.user_id
+		WHERE c.id = $1 AND c.hazard_id = $2 AND c.deleted_at IS NULL
+	`
+
+	comment, err := scanComment(r.db.QueryRowContext(ctx, query, id, hazardID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return comment, err
+}
+
+// List returns a page of a hazard's comments, oldest first. Comments by
+// shadow-banned users are left out unless viewerID wrote them or
+// moderator is set.
+func (r *Repository) List(ctx context.Context, hazardID, viewerID uuid.UUID, moderator bool, after string, limit int) (*models.CommentPage, error) {
+	c := &pagination.Cursor{}
+	if after != "" {
+		var err error
+		if c, err = pagination.DecodeCursor(after); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		SELECT ` + commentColumns + `
+		FROM hazard_comments c JOIN users u ON u.id = c.user_id
+		WHERE c.hazard_id = $1 AND c.deleted_at IS NULL
+		  AND (c.created_at, c.id) > ($2, $3)
+		  AND ($5 OR u.status <> 'shadow_banned' OR c.user_id = $6)
+		ORDER BY c.created_at ASC, c.id ASC
+		LIMIT $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, hazardID, c.CreatedAt, c.ID, limit+1, moderator, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.CommentPage{Comments: []*models.Comment{}}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Comments) == limit {
+			last := page.Comments[limit-1]
+			next := pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+			page.NextCursor = &next
+			break
+		}
+
+		page.Comments = append(page.Comments, comment)
+	}
+
+	return page, rows.Err()
+}
+
+func (r *Repository) UpdateBody(ctx context.Context, comment *models.Comment, body string) error {
+	query := `
+		UPDATE hazard_comments SET body = $2, edited = TRUE
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query, comment.ID, body).Scan(&comment.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	comment.Body = body
+	comment.Edited = true
+	return nil
+}
+
+func (r *Repository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE hazard_comments SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *Repository) Report(ctx context.Context, commentID, userID uuid.UUID, report *models.CommentReport) error {
+	query := `
+		INSERT INTO comment_reports (comment_id, user_id, reason, details)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (comment_id, user_id) DO NOTHING
+	`
+
+	result, err := r.db.ExecContext(ctx, query, commentID, userID, report.Reason, report.Details)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAlreadyReported
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	// HazardChannel carries HazardEvents to the worker and every API replica.
+	HazardChannel = "hazard:events"
+	// AreaChannel carries stream area updates so that whichever replica
+	// holds the stream can apply them.
+	AreaChannel = "hazard:stream:area"
+)
+
+type EventType string
+
+const (
+	HazardCreated  EventType = "hazard.created"
+	HazardUpdated  EventType = "hazard.updated"
+	HazardResolved EventType = "hazard.resolved"
+	HazardDeleted  EventType = "hazard.deleted"
+	// HazardHidden tells clients to drop a hazard pending moderation.
+	HazardHidden EventType = "hazard.hidden"
+	// HazardCommented marks discussion activity on a hazard.
+	HazardCommented EventType = "hazard.commented"
+)
+
+type HazardEvent struct {
+	ID     uuid.UUID      `json:"id"`
+	Type   EventType      `json:"type"`
+	Hazard *models.Hazard `json:"hazard"`
+	// Comment is the new comment of a HazardCommented event.
+	Comment    *models.Comment `json:"comment,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+func NewHazardEvent(eventType EventType, hazard *models.Hazard) *HazardEvent {
+	return &HazardEvent{
+		ID:         uuid.New(),
+		Type:       eventType,
+		Hazard:     hazard,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+func NewCommentEvent(hazard *models.Hazard, comment *models.Comment) *HazardEvent {
+	event := NewHazardEvent(HazardCommented, hazard)
+	event.Comment = comment
+	return event
+}
+
+// Area is a circular region a stream subscriber is interested in.
+type Area struct {
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	RadiusKm  float64 `json:"radius"`
+}
+
+type AreaUpdate struct {
+	SubscriptionID uuid.UUID `json:"subscription_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	Area           Area      `json:"area"`
+}
+
+// Bus publishes hazard events over Redis pub/sub.
+type Bus struct {
+	client *redis.Client
+}
+
+func NewBus(client *redis.Client) *Bus {
+	return &Bus{client: client}
+}
+
+func (b *Bus) Publish(ctx context.Context, event *HazardEvent) error {
+	return b.publish(ctx, HazardChannel, event)
+}
+
+func (b *Bus) PublishArea(ctx context.Context, update *AreaUpdate) error {
+	return b.publish(ctx, AreaChannel, update)
+}
+
+func (b *Bus) Subscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	return b.client.Subscribe(ctx, channels...)
+}
+
+func (b *Bus) publish(ctx context.Context, channel string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, channel, payload).Err()
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/geo"
+)
+
+// SubscriberBuffer is how many events may queue for a stream before the hub
+// treats it as a slow consumer and disconnects it.
+const SubscriberBuffer = 64
+
+// Subscriber is one open hazard stream on this replica.
+type Subscriber struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+
+	mu   sync.RWMutex
+	area Area
+
+	events  chan *HazardEvent
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// Events delivers hazard events inside the subscriber's area.
+func (s *Subscriber) Events() <-chan *HazardEvent {
+	return s.events
+}
+
+// Dropped is closed when the hub disconnects the subscriber for falling
+// behind.
+func (s *Subscriber) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+func (s *Subscriber) Area() Area {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.area
+}
+
+func (s *Subscriber) setArea(area Area) {
+	s.mu.Lock()
+	s.area = area
+	s.mu.Unlock()
+}
+
+func (s *Subscriber) matches(event *HazardEvent) bool {
+	if event.Hazard == nil {
+		return false
+	}
+	area := s.Area()
+	center := geo.Point{Lat: area.Latitude, Lon: area.Longitude}
+	location := geo.Point{Lat: event.Hazard.Latitude, Lon: event.Hazard.Longitude}
+	return geo.DistanceKm(center, location) <= area.RadiusKm
+}
+
+func (s *Subscriber) drop() {
+	s.once.Do(func() { close(s.dropped) })
+}
+
+// Hub fans hazard events from the bus out to the streams held by this
+// replica. Every replica runs its own hub against the same Redis channels.
+type Hub struct {
+	bus *Bus
+
+	mu   sync.RWMutex
+	subs map[uuid.UUID]*Subscriber
+}
+
+func NewHub(bus *Bus) *Hub {
+	return &Hub{
+		bus:  bus,
+		subs: make(map[uuid.UUID]*Subscriber),
+	}
+}
+
+// Run consumes the bus until ctx is cancelled.
+func (h *Hub) Run(ctx context.Context) {
+	pubsub := h.bus.Subscribe(ctx, HazardChannel, AreaChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			switch msg.Channel {
+			case HazardChannel:
+				var event HazardEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					log.Printf("Failed to unmarshal hazard event: %v", err)
+					continue
+				}
+				h.dispatch(&event)
+			case AreaChannel:
+				var update AreaUpdate
+				if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+					log.Printf("Failed to unmarshal area update: %v", err)
+					continue
+				}
+				h.applyArea(&update)
+			}
+		}
+	}
+}
+
+func (h *Hub) Subscribe(userID uuid.UUID, area Area) *Subscriber {
+	sub := &Subscriber{
+		ID:      uuid.New(),
+		UserID:  userID,
+		area:    area,
+		events:  make(chan *HazardEvent, SubscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.subs[sub.ID] = sub
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub.ID)
+	h.mu.Unlock()
+}
+
+// UpdateArea moves a subscription's area. The update goes through the bus
+// because the stream may be held by another replica.
+func (h *Hub) UpdateArea(ctx context.Context, update *AreaUpdate) error {
+	return h.bus.PublishArea(ctx, update)
+}
+
+func (h *Hub) dispatch(event *HazardEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, sub := range h.subs {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.drop()
+		}
+	}
+}
+
+func (h *Hub) applyArea(update *AreaUpdate) {
+	h.mu.RLock()
+	sub, ok := h.subs[update.SubscriptionID]
+	h.mu.RUnlock()
+
+	if ok && sub.UserID == update.UserID {
+		sub.setArea(update.Area)
+	}
+}
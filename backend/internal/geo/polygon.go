@@ -0,0 +1,41 @@
+package geo
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PolygonEWKT renders rings, the outer ring first, as an SRID-tagged WKT
+// POLYGON suitable for ST_GeomFromEWKT. Rings must already be closed.
+func PolygonEWKT(rings [][]Point) string {
+	var b strings.Builder
+	b.WriteString("SRID=4326;POLYGON(")
+	for i, ring := range rings {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("(")
+		for j, p := range ring {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strconv.FormatFloat(p.Lon, 'f', -1, 64))
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(p.Lat, 'f', -1, 64))
+		}
+		b.WriteString(")")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// BBoxRing returns the closed ring around a bounding box.
+func BBoxRing(minLon, minLat, maxLon, maxLat float64) []Point {
+	return []Point{
+		{Lat: minLat, Lon: minLon},
+		{Lat: minLat, Lon: maxLon},
+		{Lat: maxLat, Lon: maxLon},
+		{Lat: maxLat, Lon: minLon},
+		{Lat: minLat, Lon: minLon},
+	}
+}
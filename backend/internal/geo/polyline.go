@@ -0,0 +1,82 @@
+package geo
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPolyline = errors.New("invalid encoded polyline")
+
+// Point is a WGS84 coordinate.
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+// DecodePolyline decodes a string in Google's encoded polyline format with
+// five decimal places of precision.
+func DecodePolyline(encoded string) ([]Point, error) {
+	var points []Point
+	var lat, lon int
+	i := 0
+
+	for i < len(encoded) {
+		dlat, n, err := decodeValue(encoded[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+
+		dlon, n, err := decodeValue(encoded[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+
+		lat += dlat
+		lon += dlon
+		points = append(points, Point{Lat: float64(lat) / 1e5, Lon: float64(lon) / 1e5})
+	}
+
+	return points, nil
+}
+
+func decodeValue(s string) (int, int, error) {
+	var result, shift int
+	for i := 0; i < len(s); i++ {
+		b := int(s[i]) - 63
+		if b < 0 || b > 63 {
+			return 0, 0, ErrInvalidPolyline
+		}
+		result |= (b & 0x1f) << shift
+		shift += 5
+		if b < 0x20 {
+			if result&1 != 0 {
+				return ^(result >> 1), i + 1, nil
+			}
+			return result >> 1, i + 1, nil
+		}
+		if shift > 30 {
+			return 0, 0, ErrInvalidPolyline
+		}
+	}
+	return 0, 0, ErrInvalidPolyline
+}
+
+// LineStringEWKT renders points as an SRID-tagged WKT LINESTRING suitable for
+// ST_GeogFromText.
+func LineStringEWKT(points []Point) string {
+	var b strings.Builder
+	b.WriteString("SRID=4326;LINESTRING(")
+	for i, p := range points {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(strconv.FormatFloat(p.Lon, 'f', -1, 64))
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatFloat(p.Lat, 'f', -1, 64))
+	}
+	b.WriteString(")")
+	return b.String()
+}
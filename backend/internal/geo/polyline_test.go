@@ -0,0 +1,90 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    []Point
+		wantErr bool
+	}{
+		{
+			name:    "reference example",
+			encoded: "_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+			want:    []Point{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}},
+		},
+		{name: "empty", encoded: "", want: nil},
+		{name: "origin", encoded: "??", want: []Point{{0, 0}}},
+		{name: "latitude without longitude", encoded: "_p~iF", wantErr: true},
+		{name: "truncated value", encoded: "_p~iF~ps|", wantErr: true},
+		{name: "character out of range", encoded: "_p~iF ps|U", wantErr: true},
+		{name: "value too long", encoded: "~~~~~~~~?", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePolyline(tt.encoded)
+			if tt.wantErr {
+				if err != ErrInvalidPolyline {
+					t.Errorf("DecodePolyline() error = %v, want ErrInvalidPolyline", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodePolyline() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("DecodePolyline() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i].Lat-tt.want[i].Lat) > 1e-9 || math.Abs(got[i].Lon-tt.want[i].Lon) > 1e-9 {
+					t.Errorf("point %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLineStringEWKT(t *testing.T) {
+	tests := []struct {
+		points []Point
+		want   string
+	}{
+		{
+			points: []Point{{Lat: 38.5, Lon: -120.2}, {Lat: 40.7, Lon: -120.95}},
+			want:   "SRID=4326;LINESTRING(-120.2 38.5,-120.95 40.7)",
+		},
+		{
+			points: []Point{{Lat: 0, Lon: 179.99999}, {Lat: -0.5, Lon: -179.99999}},
+			want:   "SRID=4326;LINESTRING(179.99999 0,-179.99999 -0.5)",
+		},
+	}
+	for _, tt := range tests {
+		if got := LineStringEWKT(tt.points); got != tt.want {
+			t.Errorf("LineStringEWKT(%v) = %q, want %q", tt.points, got, tt.want)
+		}
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{"same point", Point{52.52, 13.405}, Point{52.52, 13.405}, 0},
+		{"one degree of latitude", Point{0, 0}, Point{1, 0}, 111.195},
+		{"across the antimeridian", Point{0, 179.5}, Point{0, -179.5}, 111.195},
+		{"Paris to London", Point{48.8566, 2.3522}, Point{51.5074, -0.1278}, 343.56},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DistanceKm(tt.a, tt.b); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("DistanceKm() = %.3f, want %.3f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/apikeys"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// APIKeyHandler is the admin API for partner keys.
+type APIKeyHandler struct {
+	repo  *apikeys.Repository
+	store *auth.APIKeyStore
+}
+
+func NewAPIKeyHandler(repo *apikeys.Repository, store *auth.APIKeyStore) *APIKeyHandler {
+	return &APIKeyHandler{repo: repo, store: store}
+}
+
+// Create issues a key. The response is the only time the key itself is
+// shown.
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.APIKeyCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validateAPIKeyCreate(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.repo.Create(r.Context(), &req, adminID)
+	if errors.Is(err, apikeys.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// List returns all keys, or those of ?user_id=.
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	var userID *uuid.UUID
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = &id
+	}
+
+	keys, err := h.repo.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"api_keys": keys})
+}
+
+func (h *APIKeyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.repo.GetByID(r.Context(), id)
+	if errors.Is(err, apikeys.ErrNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func (h *APIKeyHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.APIKeyUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateAPIKeyUpdate(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.repo.Update(r.Context(), id, &req)
+	if errors.Is(err, apikeys.ErrNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update API key", http.StatusInternalServerError)
+		return
+	}
+	h.store.Invalidate(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// Revoke disables a key permanently.
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.repo.Revoke(r.Context(), id)
+	if errors.Is(err, apikeys.ErrNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+	h.store.Invalidate(id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateAPIKeyCreate(req *models.APIKeyCreate) error {
+	if req.UserID == uuid.Nil {
+		return errors.New("User ID is required")
+	}
+	if req.Name == "" || len(req.Name) > 100 {
+		return errors.New("Name must be 1 to 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		return errors.New("At least one scope is required")
+	}
+	return validateAPIKeyLimits(req.Scopes, req.RateLimit, req.ExpiresAt)
+}
+
+func validateAPIKeyUpdate(req *models.APIKeyUpdate) error {
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		if *req.Name == "" || len(*req.Name) > 100 {
+			return errors.New("Name must be 1 to 100 characters")
+		}
+	}
+	if req.Scopes != nil && len(req.Scopes) == 0 {
+		return errors.New("At least one scope is required")
+	}
+	return validateAPIKeyLimits(req.Scopes, req.RateLimit, req.ExpiresAt)
+}
+
+func validateAPIKeyLimits(scopes []models.APIKeyScope, rateLimit *int, expiresAt *time.Time) error {
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return errors.New("Invalid scope: " + string(scope))
+		}
+	}
+	if rateLimit != nil && *rateLimit < 1 {
+		return errors.New("Rate limit must be positive")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("Expiry must be in the future")
+	}
+	return nil
+}
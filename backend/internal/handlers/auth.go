@@ -1,27 +1,53 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/idempotency"
+	"github.com/roadeye/backend/internal/mail"
 	"github.com/roadeye/backend/pkg/models"
 )
 
 type AuthHandler struct {
-	db         *sql.DB
-	jwtManager *auth.JWTManager
+	db          *sql.DB
+	jwtManager  *auth.JWTManager
+	passwords   *auth.PasswordManager
+	guard       *auth.LoginGuard
+	idempotency *idempotency.Store
+	mailer      mail.Mailer
+	appURL      string
 }
 
-func NewAuthHandler(db *sql.DB, jwtManager *auth.JWTManager) *AuthHandler {
+func NewAuthHandler(db *sql.DB, jwtManager *auth.JWTManager, passwords *auth.PasswordManager, guard *auth.LoginGuard, idempotency *idempotency.Store, mailer mail.Mailer, appURL string) *AuthHandler {
 	return &AuthHandler{
-		db:         db,
-		jwtManager: jwtManager,
+		db:          db,
+		jwtManager:  jwtManager,
+		passwords:   passwords,
+		guard:       guard,
+		idempotency: idempotency,
+		mailer:      mailer,
+		appURL:      appURL,
 	}
 }
 
+var errAccountExists = errors.New("email or username already exists")
+
+// Register creates an account and signs it in. An Idempotency-Key makes
+// retries from the same address safe: the key only remembers the new
+// user's ID, and a retry with the same password gets fresh tokens for it.
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.UserCreate
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -29,20 +55,60 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Hash password
-	passwordHash, err := auth.HashPassword(req.Password)
+	if err := h.passwords.Validate(req.Password); err != nil {
+		http.Error(w, h.passwordPolicyMessage(err), http.StatusBadRequest)
+		return
+	}
+
+	var user *models.User
+	create := func() (string, error) {
+		var err error
+		user, err = h.createUser(r.Context(), &req)
+		if err != nil {
+			return "", err
+		}
+		return user.ID.String(), nil
+	}
+
+	key := r.Header.Get(idempotency.HeaderKey)
+	if key == "" {
+		if _, err := create(); err != nil {
+			registerFailed(w, err)
+			return
+		}
+		h.issueTokens(w, user, false)
+		return
+	}
+
+	// The password is left out of the fingerprint, so no fast hash of it is
+	// stored, and checked against the account on replay instead.
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	fingerprint := idempotency.Fingerprint(r, []byte(email+"\n"+req.Username))
+	id, replayed, err := h.idempotency.Once(r.Context(), "register:"+clientIP(r), key, fingerprint, create)
 	if err != nil {
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		registerFailed(w, err)
 		return
 	}
+	if replayed {
+		h.replayRegistration(w, r, id, req.Password)
+		return
+	}
+	h.issueTokens(w, user, false)
+}
+
+func (h *AuthHandler) createUser(ctx context.Context, req *models.UserCreate) (*models.User, error) {
+	passwordHash, err := h.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create user
 	user := &models.User{
 		ID:           uuid.New(),
 		Username:     req.Username,
 		Email:        req.Email,
 		PasswordHash: passwordHash,
 		Points:       0,
+		Role:         models.UserRoleUser,
 	}
 
 	query := `
@@ -51,32 +117,66 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		RETURNING created_at, updated_at
 	`
 
-	err = h.db.QueryRow(query, user.ID, user.Username, user.Email, user.PasswordHash, user.Points).
+	err = h.db.QueryRowContext(ctx, query, user.ID, user.Username, user.Email, user.PasswordHash, user.Points).
 		Scan(&user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		http.Error(w, "Email or username already exists", http.StatusConflict)
-		return
+		return nil, errAccountExists
 	}
+	return user, nil
+}
 
-	// Generate tokens
-	token, err := h.jwtManager.GenerateToken(user.ID, user.Email)
+// replayRegistration answers a retried registration by signing in to the
+// account it created, as long as the password still matches.
+func (h *AuthHandler) replayRegistration(w http.ResponseWriter, r *http.Request, id, password string) {
+	user := &models.User{}
+	var status models.AccountStatus
+	var totpEnabledAt sql.NullTime
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT id, username, email, password_hash, points, role, avatar, status, totp_enabled_at, created_at, updated_at
+		FROM users WHERE id = $1
+	`, id).Scan(
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
+		&user.Points, &user.Role, &user.Avatar, &status, &totpEnabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, _ := h.jwtManager.GenerateRefreshToken(user.ID, user.Email)
-
-	response := models.AuthResponse{
-		User:         user,
-		Token:        token,
-		RefreshToken: refreshToken,
+	if ok, _ := h.passwords.Verify(password, user.PasswordHash); !ok {
+		http.Error(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Header().Set(idempotency.HeaderReplayed, "true")
+	h.completeLogin(w, user, status, totpEnabledAt.Valid)
+}
+
+func registerFailed(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errAccountExists):
+		http.Error(w, "Email or username already exists", http.StatusConflict)
+	case errors.Is(err, idempotency.ErrKeyTooLong):
+		http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+	case errors.Is(err, idempotency.ErrInProgress):
+		http.Error(w, "Request with this Idempotency-Key is in progress", http.StatusConflict)
+	case errors.Is(err, idempotency.ErrKeyReused):
+		http.Error(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+	case errors.Is(err, idempotency.ErrUnavailable):
+		http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+	}
 }
 
+// Login checks credentials. Repeated failures make the account and the
+// client address back off exponentially, and LockoutThreshold failures lock
+// the account until the emailed unlock link is used or the lockout expires.
+// Unknown emails go through the same steps as wrong passwords.
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.UserLogin
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -84,51 +184,232 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	if !h.allowLoginAttempt(w, r, req.Email, ip) {
+		return
+	}
+
 	// Get user by email
 	user := &models.User{}
+	var status models.AccountStatus
+	var totpEnabledAt sql.NullTime
 	query := `
-		SELECT id, username, email, password_hash, points, avatar, created_at, updated_at
+		SELECT id, username, email, password_hash, points, role, avatar, status, totp_enabled_at, created_at, updated_at
 		FROM users WHERE email = $1
 	`
 
 	err := h.db.QueryRow(query, req.Email).Scan(
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.Points, &user.Avatar, &user.CreatedAt, &user.UpdatedAt,
+		&user.Points, &user.Role, &user.Avatar, &status, &totpEnabledAt, &user.CreatedAt, &user.UpdatedAt,
 	)
-	if err == sql.ErrNoRows {
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	found := err == nil
+
+	// Check password
+	var valid, rehash bool
+	if found {
+		valid, rehash = h.passwords.Verify(req.Password, user.PasswordHash)
+	} else {
+		valid = h.passwords.Reject(req.Password)
+	}
+	if !valid {
+		h.loginFailed(r, req.Email, found)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if rehash {
+		h.rehashPassword(r.Context(), user, req.Password)
+	}
+
+	// Accounts with 2FA reset the failure count at VerifyTwoFactor instead,
+	// so wrong codes keep adding up
+	if totpEnabledAt.Valid {
+		err = h.guard.Release(r.Context(), req.Email, ip)
+	} else {
+		err = h.guard.Succeed(r.Context(), req.Email, ip)
+	}
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	h.completeLogin(w, user, status, totpEnabledAt.Valid)
+}
+
+// completeLogin answers a successful first-factor login, from a password or
+// an identity provider. Accounts with 2FA get a challenge to finish at
+// VerifyTwoFactor instead of tokens.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, user *models.User, status models.AccountStatus, twoFactorEnabled bool) {
+	if status == models.AccountStatusBanned {
+		http.Error(w, "Account is banned", http.StatusForbidden)
 		return
 	}
 
-	// Check password
-	if !auth.CheckPassword(req.Password, user.PasswordHash) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if twoFactorEnabled {
+		challenge, err := h.jwtManager.GenerateChallengeToken(user.ID, user.Email, user.Role)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&models.TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int(auth.ChallengeExpiry.Seconds()),
+		})
+		return
+	}
+
+	h.issueTokens(w, user, false)
+}
+
+// rehashPassword upgrades a bcrypt or outdated argon2id hash after a
+// successful login. Failures only mean the upgrade waits for the next login.
+func (h *AuthHandler) rehashPassword(ctx context.Context, user *models.User, password string) {
+	hash, err := h.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Failed to rehash password: %v", err)
 		return
 	}
 
-	// Generate tokens
-	token, err := h.jwtManager.GenerateToken(user.ID, user.Email)
+	_, err = h.db.ExecContext(ctx, `
+		UPDATE users SET password_hash = $3
+		WHERE id = $1 AND password_hash = $2
+	`, user.ID, user.PasswordHash, hash)
+	if err != nil {
+		log.Printf("Failed to store rehashed password: %v", err)
+		return
+	}
+	user.PasswordHash = hash
+}
+
+func (h *AuthHandler) passwordPolicyMessage(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrPasswordTooShort):
+		return fmt.Sprintf("Password must be at least %d characters", h.passwords.Policy().MinLength)
+	case errors.Is(err, auth.ErrPasswordTooLong):
+		return fmt.Sprintf("Password must be at most %d bytes", auth.MaxPasswordLength)
+	case errors.Is(err, auth.ErrPasswordCommon):
+		return "Password is too common, choose another"
+	default:
+		return "Invalid password"
+	}
+}
+
+// allowLoginAttempt refuses the attempt while the account is locked or the
+// email or IP is backing off after failures, and otherwise counts it until
+// it succeeds.
+func (h *AuthHandler) allowLoginAttempt(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	block, err := h.guard.Attempt(r.Context(), email, ip)
+	if err != nil {
+		log.Printf("Login guard unavailable: %v", err)
+	}
+	if block == nil {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(block.RetryAfter.Seconds()))))
+	if block.Locked {
+		http.Error(w, "Account temporarily locked, check your email to unlock it", http.StatusLocked)
+	} else {
+		http.Error(w, "Too many failed login attempts, retry later", http.StatusTooManyRequests)
+	}
+	return false
+}
+
+// issueTokens completes a login. Privileged roles only act as such with
+// tokens from a two-factor login, so they are told to set it up otherwise.
+func (h *AuthHandler) issueTokens(w http.ResponseWriter, user *models.User, twoFactor bool) {
+	token, err := h.jwtManager.GenerateToken(user.ID, user.Email, user.Role, twoFactor)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, _ := h.jwtManager.GenerateRefreshToken(user.ID, user.Email)
+	refreshToken, _ := h.jwtManager.GenerateRefreshToken(user.ID, user.Email, user.Role, twoFactor)
 
 	response := models.AuthResponse{
-		User:         user,
-		Token:        token,
-		RefreshToken: refreshToken,
+		User:                   user,
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		TwoFactorSetupRequired: user.Role.RequiresTwoFactor() && !twoFactor,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// Unlock lifts a login lockout using the token from the unlock email.
+func (h *AuthHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	var req models.UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.guard.Unlock(r.Context(), req.Token)
+	if err != nil {
+		http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid or expired unlock token", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Account unlocked"})
+}
+
+// loginFailed marks a login as failed and, when it locks an existing
+// account, emails the owner an unlock link. The email is sent in the
+// background so the response takes no longer than for unknown emails.
+func (h *AuthHandler) loginFailed(r *http.Request, email string, found bool) {
+	locked, err := h.guard.Fail(r.Context(), email)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if !locked || !found {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		token, err := h.guard.IssueUnlockToken(ctx, email)
+		if err != nil {
+			log.Printf("Failed to issue unlock token: %v", err)
+			return
+		}
+
+		msg := &mail.Message{
+			To:      email,
+			Subject: "Your RoadEye account has been locked",
+			Body: fmt.Sprintf("There were too many failed attempts to sign in to your account, so it has been "+
+				"locked for %d minutes.\n\nIf this was you, unlock it now:\n%s/unlock?token=%s\n\n"+
+				"If it was not you, consider changing your password once you are signed in.\n",
+				int(auth.LockoutDuration.Minutes()), h.appURL, token),
+		}
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send unlock email: %v", err)
+		}
+	}()
+}
+
+// clientIP returns the client address as set by the RealIP middleware.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserIDFromContext(r.Context())
 	if !ok {
@@ -138,13 +419,13 @@ func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 
 	user := &models.User{}
 	query := `
-		SELECT id, username, email, points, avatar, created_at, updated_at
+		SELECT id, username, email, points, role, avatar, locale, units, created_at, updated_at
 		FROM users WHERE id = $1
 	`
 
 	err := h.db.QueryRow(query, userID).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Points,
-		&user.Avatar, &user.CreatedAt, &user.UpdatedAt,
+		&user.Role, &user.Avatar, &user.Locale, &user.Units, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
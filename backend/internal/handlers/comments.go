@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/comments"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/hazards"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+type CommentHandler struct {
+	repo    *comments.Repository
+	hazards *hazards.Repository
+	filter  comments.Filter
+	bus     *events.Bus
+}
+
+func NewCommentHandler(repo *comments.Repository, hazardRepo *hazards.Repository, filter comments.Filter, bus *events.Bus) *CommentHandler {
+	return &CommentHandler{
+		repo:    repo,
+		hazards: hazardRepo,
+		filter:  filter,
+		bus:     bus,
+	}
+}
+
+func (h *CommentHandler) List(w http.ResponseWriter, r *http.Request) {
+	hazardID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := comments.DefaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > comments.MaxLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if hazard, err := h.hazards.GetByID(r.Context(), hazardID); err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	viewerID, _ := auth.GetUserIDFromContext(r.Context())
+	moderator := auth.GetRoleFromContext(r.Context()).CanModerate()
+	page, err := h.repo.List(r.Context(), hazardID, viewerID, moderator, r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, comments.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	hazardID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.CommentCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	body, status, err := h.cleanBody(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	hazard, err := h.hazards.GetByID(r.Context(), hazardID)
+	if err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	comment := &models.Comment{
+		ID:       uuid.New(),
+		HazardID: hazardID,
+		UserID:   userID,
+		Body:     body,
+	}
+
+	if err := h.repo.Create(r.Context(), comment); err != nil {
+		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+		return
+	}
+
+	// The worker tells the reporter and earlier commenters
+	h.publish(r, hazard, comment)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"comment": comment})
+}
+
+func (h *CommentHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID {
+		http.Error(w, "Only the author can edit this comment", http.StatusForbidden)
+		return
+	}
+
+	var req models.CommentCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	body, status, err := h.cleanBody(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := h.repo.UpdateBody(r.Context(), comment, body); err != nil {
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"comment": comment})
+}
+
+func (h *CommentHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != userID && !auth.GetRoleFromContext(r.Context()).CanModerate() {
+		http.Error(w, "Only the author or a moderator can delete this comment", http.StatusForbidden)
+		return
+	}
+
+	if err := h.repo.Delete(r.Context(), comment.ID); err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Comment deleted"})
+}
+
+func (h *CommentHandler) Report(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CommentReport
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Reason.Valid() {
+		http.Error(w, "Invalid reason", http.StatusBadRequest)
+		return
+	}
+	if req.Details != nil && len(*req.Details) > 500 {
+		http.Error(w, "Details must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.Report(r.Context(), comment.ID, userID, &req)
+	if errors.Is(err, comments.ErrAlreadyReported) {
+		http.Error(w, "You already reported this comment", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Comment reported"})
+}
+
+// loadComment returns the comment in the URL, provided the caller may see
+// its hazard.
+func (h *CommentHandler) loadComment(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	hazardID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	commentID, err := uuid.Parse(chi.URLParam(r, "commentID"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if hazard, err := h.hazards.GetByID(r.Context(), hazardID); err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	comment, err := h.repo.GetByID(r.Context(), hazardID, commentID)
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return comment, true
+}
+
+// cleanBody trims and length-checks a comment, then runs it through the
+// configured filters. It returns the status to use if the body is refused.
+func (h *CommentHandler) cleanBody(body string) (string, int, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > comments.MaxBodyLen {
+		return "", http.StatusBadRequest, errors.New("Comment must be between 1 and 1000 characters")
+	}
+
+	filtered, err := h.filter.Filter(body)
+	if err != nil {
+		return "", http.StatusUnprocessableEntity, err
+	}
+
+	return filtered, 0, nil
+}
+
+// publish announces comment on the event bus, unless it or its hazard is
+// shadowed.
+func (h *CommentHandler) publish(r *http.Request, hazard *models.Hazard, comment *models.Comment) {
+	if hazard.Shadowed || comment.Shadowed {
+		return
+	}
+	if err := h.bus.Publish(r.Context(), events.NewCommentEvent(hazard, comment)); err != nil {
+		log.Printf("Failed to publish %s for hazard %s: %v", events.HazardCommented, hazard.ID, err)
+	}
+}
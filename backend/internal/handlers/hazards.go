@@ -1,23 +1,64 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/geo"
 	"github.com/roadeye/backend/internal/hazards"
 	"github.com/roadeye/backend/pkg/models"
 )
 
 type HazardHandler struct {
 	repo *hazards.Repository
+	bus  *events.Bus
 }
 
-func NewHazardHandler(repo *hazards.Repository) *HazardHandler {
-	return &HazardHandler{repo: repo}
+func NewHazardHandler(repo *hazards.Repository, bus *events.Bus) *HazardHandler {
+	return &HazardHandler{repo: repo, bus: bus}
+}
+
+func (h *HazardHandler) publish(ctx context.Context, eventType events.EventType, hazard *models.Hazard) {
+	publishHazard(ctx, h.bus, eventType, hazard)
+}
+
+// publishHazard announces a hazard change on the event bus. Failures are
+// logged rather than returned: the change itself has already been committed.
+// Shadowed hazards are never announced.
+func publishHazard(ctx context.Context, bus *events.Bus, eventType events.EventType, hazard *models.Hazard) {
+	if hazard.Shadowed {
+		return
+	}
+	if err := bus.Publish(ctx, events.NewHazardEvent(eventType, hazard)); err != nil {
+		log.Printf("Failed to publish %s for hazard %s: %v", eventType, hazard.ID, err)
+	}
+}
+
+// canView reports whether the caller may see hazard. Hidden hazards are
+// shown only to moderators, shadowed ones also to their reporter.
+func canView(r *http.Request, hazard *models.Hazard) bool {
+	if auth.GetRoleFromContext(r.Context()).CanModerate() {
+		return true
+	}
+	if hazard.HiddenAt != nil {
+		return false
+	}
+	if hazard.Shadowed {
+		userID, _ := auth.GetUserIDFromContext(r.Context())
+		return hazard.UserID == userID
+	}
+	return true
 }
 
 func (h *HazardHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -33,35 +74,120 @@ func (h *HazardHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hazard := &models.Hazard{
-		ID:          uuid.New(),
-		UserID:      userID,
-		Type:        req.Type,
-		Latitude:    req.Latitude,
-		Longitude:   req.Longitude,
-		Severity:    req.Severity,
-		Description: req.Description,
-		IsVerified:  false,
-		VerifyCount: 0,
+	if err := validateHazardCreate(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	hazard := newHazard(userID, &req)
+
 	// TODO: Handle image upload if ImageBase64 is provided
 	// Upload to S3 and set hazard.ImageURL
 
-	if err := h.repo.Create(r.Context(), hazard); err != nil {
+	err := h.repo.Create(r.Context(), hazard)
+	if errors.Is(err, hazards.ErrQuotaExceeded) {
+		http.Error(w, "Daily report quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to create hazard", http.StatusInternalServerError)
 		return
 	}
 
+	h.publish(r.Context(), events.HazardCreated, hazard)
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteStatus(http.StatusCreated)
+	w.Header().Set("ETag", hazardETag(hazard))
+	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{"hazard": hazard})
 }
 
+// maxCaptureSkew tolerates clock drift on devices reporting captured_at.
+const maxCaptureSkew = 5 * time.Minute
+
+// CreateBatch uploads reports queued while offline. Each item is handled
+// independently and reported back with its own status.
+func (h *HazardHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.HazardBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Items) == 0 || len(req.Items) > hazards.MaxBatchSize {
+		http.Error(w, "Batch must contain between 1 and 100 items", http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*models.HazardBatchResult, len(req.Items))
+	for i := range req.Items {
+		item := &req.Items[i]
+		result := &models.HazardBatchResult{Index: i, IdempotencyKey: item.IdempotencyKey}
+		results[i] = result
+
+		if err := validateBatchItem(item); err != nil {
+			result.Status = models.BatchItemFailed
+			result.Error = err.Error()
+			continue
+		}
+
+		hazard := newHazard(userID, &item.HazardCreate)
+		hazard.ClientKey = &item.IdempotencyKey
+		hazard.CapturedAt = item.CapturedAt
+
+		saved, created, err := h.repo.CreateIdempotent(r.Context(), hazard)
+		if errors.Is(err, hazards.ErrQuotaExceeded) {
+			result.Status = models.BatchItemFailed
+			result.Error = "Daily report quota exceeded"
+			continue
+		}
+		if err != nil {
+			result.Status = models.BatchItemFailed
+			result.Error = "Failed to create hazard"
+			continue
+		}
+
+		result.Hazard = saved
+		if created {
+			result.Status = models.BatchItemCreated
+			h.publish(r.Context(), events.HazardCreated, saved)
+		} else {
+			result.Status = models.BatchItemDuplicate
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
 func (h *HazardHandler) GetNearby(w http.ResponseWriter, r *http.Request) {
-	latStr := r.URL.Query().Get("lat")
-	lonStr := r.URL.Query().Get("lon")
-	radiusStr := r.URL.Query().Get("radius")
+	params := r.URL.Query()
+
+	filter, err := parseHazardFilter(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.ViewerID, _ = auth.GetUserIDFromContext(r.Context())
+
+	if bboxStr := params.Get("bbox"); bboxStr != "" {
+		bbox, err := parseBBox(bboxStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.getViewport(w, r, &models.ViewportQuery{BBox: *bbox, HazardFilter: *filter})
+		return
+	}
+
+	latStr := params.Get("lat")
+	lonStr := params.Get("lon")
 
 	if latStr == "" || lonStr == "" {
 		http.Error(w, "lat and lon parameters required", http.StatusBadRequest)
@@ -69,35 +195,279 @@ func (h *HazardHandler) GetNearby(w http.ResponseWriter, r *http.Request) {
 	}
 
 	lat, err := strconv.ParseFloat(latStr, 64)
-	if err != nil {
+	if err != nil || lat < -90 || lat > 90 {
 		http.Error(w, "Invalid latitude", http.StatusBadRequest)
 		return
 	}
 
 	lon, err := strconv.ParseFloat(lonStr, 64)
-	if err != nil {
+	if err != nil || lon < -180 || lon > 180 {
 		http.Error(w, "Invalid longitude", http.StatusBadRequest)
 		return
 	}
 
-	radius := 5.0 // default 5km
-	if radiusStr != "" {
-		radius, err = strconv.ParseFloat(radiusStr, 64)
-		if err != nil {
+	q := &models.HazardQuery{
+		Latitude:     lat,
+		Longitude:    lon,
+		HazardFilter: *filter,
+		Cursor:       params.Get("cursor"),
+	}
+
+	if radiusStr := params.Get("radius"); radiusStr != "" {
+		radius, err := strconv.ParseFloat(radiusStr, 64)
+		if err != nil || radius < hazards.MinRadiusKm || radius > hazards.MaxRadiusKm {
 			http.Error(w, "Invalid radius", http.StatusBadRequest)
 			return
 		}
+		q.Radius = &radius
 	}
 
-	limit := 100
-	hazards, err := h.repo.GetNearby(r.Context(), lat, lon, radius, limit)
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > hazards.MaxLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = &limit
+	}
+
+	if sortStr := params.Get("sort"); sortStr != "" {
+		q.Sort = models.HazardSort(sortStr)
+		if !q.Sort.Valid() {
+			http.Error(w, "Invalid sort order", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.repo.Search(r.Context(), q)
+	if errors.Is(err, hazards.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch hazards", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"hazards": hazards})
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *HazardHandler) getViewport(w http.ResponseWriter, r *http.Request, q *models.ViewportQuery) {
+	viewport, err := h.repo.Viewport(r.Context(), q)
+	if err != nil {
+		http.Error(w, "Failed to fetch hazards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewport)
+}
+
+func (h *HazardHandler) AlongRoute(w http.ResponseWriter, r *http.Request) {
+	var req models.RouteQuery
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var route []geo.Point
+	switch {
+	case req.Polyline != nil && req.LineString != nil:
+		http.Error(w, "Provide either polyline or geometry, not both", http.StatusBadRequest)
+		return
+	case req.Polyline != nil:
+		points, err := geo.DecodePolyline(*req.Polyline)
+		if err != nil {
+			http.Error(w, "Invalid polyline", http.StatusBadRequest)
+			return
+		}
+		route = points
+	case req.LineString != nil:
+		if req.LineString.Type != "LineString" {
+			http.Error(w, "geometry must be a GeoJSON LineString", http.StatusBadRequest)
+			return
+		}
+		for _, c := range req.LineString.Coordinates {
+			route = append(route, geo.Point{Lon: c[0], Lat: c[1]})
+		}
+	default:
+		http.Error(w, "polyline or geometry required", http.StatusBadRequest)
+		return
+	}
+
+	if len(route) < 2 || len(route) > hazards.MaxRoutePoints {
+		http.Error(w, "Route must have between 2 and 5000 points", http.StatusBadRequest)
+		return
+	}
+	for _, p := range route {
+		if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+			http.Error(w, "Invalid route coordinate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	corridor := hazards.DefaultCorridorM
+	if req.CorridorM != nil {
+		corridor = *req.CorridorM
+		if corridor < 1 || corridor > hazards.MaxCorridorM {
+			http.Error(w, "Invalid corridor width", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := hazards.DefaultRouteLimit
+	if req.Limit != nil {
+		limit = *req.Limit
+		if limit < 1 || limit > hazards.MaxRouteLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, t := range req.Types {
+		if !t.Valid() {
+			http.Error(w, "Invalid hazard type", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, s := range req.Severities {
+		if !s.Valid() {
+			http.Error(w, "Invalid severity", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Status != nil && !req.Status.Valid() {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	req.ViewerID, _ = auth.GetUserIDFromContext(r.Context())
+	results, err := h.repo.AlongRoute(r.Context(), route, corridor, &req.HazardFilter, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch hazards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"hazards": results})
+}
+
+func (h *HazardHandler) Ahead(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := &models.DriveQuery{}
+
+	fields := []struct {
+		name     string
+		dest     *float64
+		min, max float64
+	}{
+		{"lat", &q.Latitude, -90, 90},
+		{"lon", &q.Longitude, -180, 180},
+		{"speed", &q.SpeedKmh, 0, 250},
+		{"heading", &q.Heading, 0, 360},
+	}
+	for _, f := range fields {
+		str := params.Get(f.name)
+		if str == "" {
+			http.Error(w, "lat, lon, speed and heading parameters required", http.StatusBadRequest)
+			return
+		}
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil || v < f.min || v > f.max {
+			http.Error(w, "Invalid "+f.name, http.StatusBadRequest)
+			return
+		}
+		*f.dest = v
+	}
+	if q.Heading == 360 {
+		q.Heading = 0
+	}
+
+	if toleranceStr := params.Get("tolerance"); toleranceStr != "" {
+		tolerance, err := strconv.ParseFloat(toleranceStr, 64)
+		if err != nil || tolerance < 5 || tolerance > 90 {
+			http.Error(w, "Invalid tolerance", http.StatusBadRequest)
+			return
+		}
+		q.ToleranceDeg = &tolerance
+	}
+
+	q.ViewerID, _ = auth.GetUserIDFromContext(r.Context())
+	results, err := h.repo.Ahead(r.Context(), q)
+	if err != nil {
+		http.Error(w, "Failed to fetch hazards", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hazards":     results,
+		"lookahead_m": hazards.LookAheadDistance(q.SpeedKmh),
+	})
+}
+
+// Changes serves delta sync: upserts and tombstones inside region since the
+// cursor returned by the previous call.
+func (h *HazardHandler) Changes(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	regionStr := params.Get("region")
+	if regionStr == "" {
+		http.Error(w, "region parameter required", http.StatusBadRequest)
+		return
+	}
+	region, err := parseBBox(regionStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cursor *hazards.ChangeCursor
+	if sinceStr := params.Get("since"); sinceStr != "" {
+		cursor, err = hazards.DecodeChangeCursor(sinceStr)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := hazards.DefaultChangesLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > hazards.MaxChangesLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	userID, _ := auth.GetUserIDFromContext(r.Context())
+	changes, err := h.repo.Changes(r.Context(), cursor, *region, limit, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch changes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// Quota reports how many hazards the caller may still report today.
+func (h *HazardHandler) Quota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	quota, err := h.repo.Quota(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch quota", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"quota": quota})
 }
 
 func (h *HazardHandler) GetByID(w http.ResponseWriter, r *http.Request) {
@@ -113,11 +483,98 @@ func (h *HazardHandler) GetByID(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Hazard not found", http.StatusNotFound)
 		return
 	}
+	if !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", hazardETag(hazard))
 	json.NewEncoder(w).Encode(map[string]interface{}{"hazard": hazard})
 }
 
+// Update applies a partial edit. Reporters may edit within
+// hazards.EditWindow of reporting, moderators at any time. The If-Match
+// header must carry the ETag the client last read.
+func (h *HazardHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	hazardID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+		return
+	}
+	version, err := parseHazardETag(ifMatch)
+	if err != nil {
+		http.Error(w, "Invalid If-Match header", http.StatusBadRequest)
+		return
+	}
+
+	var req models.HazardUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateHazardUpdate(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hazard, err := h.repo.GetByID(r.Context(), hazardID)
+	if err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	if !auth.GetRoleFromContext(r.Context()).CanModerate() {
+		if hazard.UserID != userID {
+			http.Error(w, "Only the reporter or a moderator can edit this hazard", http.StatusForbidden)
+			return
+		}
+		if time.Since(hazard.CreatedAt) > hazards.EditWindow {
+			http.Error(w, "Edit window has closed", http.StatusForbidden)
+			return
+		}
+	}
+
+	updated, changes, err := h.repo.Update(r.Context(), hazardID, userID, version, &req)
+	if errors.Is(err, hazards.ErrNotFound) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, hazards.ErrModified) {
+		http.Error(w, "Hazard was modified, reload and retry", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update hazard", http.StatusInternalServerError)
+		return
+	}
+
+	if len(changes) > 0 {
+		if updated.Status == models.HazardStatusResolved && hazard.Status != models.HazardStatusResolved {
+			h.publish(r.Context(), events.HazardResolved, updated)
+		} else {
+			h.publish(r.Context(), events.HazardUpdated, updated)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", hazardETag(updated))
+	json.NewEncoder(w).Encode(map[string]interface{}{"hazard": updated})
+}
+
 func (h *HazardHandler) Verify(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.GetUserIDFromContext(r.Context())
 	if !ok {
@@ -132,11 +589,26 @@ func (h *HazardHandler) Verify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.repo.VerifyHazard(r.Context(), hazardID, userID); err != nil {
+	hazard, err := h.repo.GetByID(r.Context(), hazardID)
+	if err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.repo.VerifyHazard(r.Context(), hazardID, userID)
+	if errors.Is(err, hazards.ErrNotFound) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to verify hazard", http.StatusInternalServerError)
 		return
 	}
 
+	if hazard, err := h.repo.GetByID(r.Context(), hazardID); err == nil {
+		h.publish(r.Context(), events.HazardUpdated, hazard)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Hazard verified"})
 }
@@ -155,14 +627,313 @@ func (h *HazardHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Check if user owns the hazard before deleting
-	_ = userID
+	var req models.HazardDelete
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Reason != nil && len(*req.Reason) > 500 {
+		http.Error(w, "Reason must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
 
-	if err := h.repo.Delete(r.Context(), hazardID); err != nil {
+	hazard, err := h.repo.GetByID(r.Context(), hazardID)
+	if err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	if hazard.UserID != userID && !auth.GetRoleFromContext(r.Context()).CanModerate() {
+		http.Error(w, "Only the reporter or a moderator can delete this hazard", http.StatusForbidden)
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), hazardID, userID, req.Reason)
+	if errors.Is(err, hazards.ErrNotFound) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Failed to delete hazard", http.StatusInternalServerError)
 		return
 	}
 
+	h.publish(r.Context(), events.HazardDeleted, hazard)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Hazard deleted"})
 }
+
+// Flag reports a hazard as abusive. Enough flags hide it from other users
+// until a moderator reviews it.
+func (h *HazardHandler) Flag(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	hazardID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.FlagCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Reason.Valid() {
+		http.Error(w, "Invalid flag reason", http.StatusBadRequest)
+		return
+	}
+	if req.Details != nil && len(*req.Details) > 500 {
+		http.Error(w, "Details must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	hazard, err := h.repo.GetByID(r.Context(), hazardID)
+	if err != nil || !canView(r, hazard) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if hazard.UserID == userID {
+		http.Error(w, "Cannot flag your own hazard", http.StatusBadRequest)
+		return
+	}
+
+	hidden, err := h.repo.Flag(r.Context(), hazardID, userID, &req)
+	if errors.Is(err, hazards.ErrNotFound) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, hazards.ErrAlreadyFlagged) {
+		http.Error(w, "Hazard already flagged", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to flag hazard", http.StatusInternalServerError)
+		return
+	}
+
+	if hidden {
+		h.publish(r.Context(), events.HazardHidden, hazard)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Hazard flagged"})
+}
+
+// History lists the audit trail of a hazard, including deleted ones.
+func (h *HazardHandler) History(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	hazardID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.repo.History(r.Context(), hazardID)
+	if err != nil {
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"history": history})
+}
+
+// splitParam flattens repeated and comma-separated query values, so both
+// ?type=pothole&type=debris and ?type=pothole,debris are accepted.
+func splitParam(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// parseHazardFilter reads the attribute filters shared by every hazard
+// listing. Errors carry the message to send back to the client.
+func parseHazardFilter(params url.Values) (*models.HazardFilter, error) {
+	filter := &models.HazardFilter{}
+
+	for _, t := range splitParam(params["type"]) {
+		hazardType := models.HazardType(t)
+		if !hazardType.Valid() {
+			return nil, errors.New("Invalid hazard type")
+		}
+		filter.Types = append(filter.Types, hazardType)
+	}
+
+	for _, s := range splitParam(params["severity"]) {
+		severity := models.HazardSeverity(s)
+		if !severity.Valid() {
+			return nil, errors.New("Invalid severity")
+		}
+		filter.Severities = append(filter.Severities, severity)
+	}
+
+	if verifiedStr := params.Get("verified"); verifiedStr != "" {
+		verified, err := strconv.ParseBool(verifiedStr)
+		if err != nil {
+			return nil, errors.New("Invalid verified flag")
+		}
+		filter.VerifiedOnly = verified
+	}
+
+	if statusStr := params.Get("status"); statusStr != "" {
+		status := models.HazardStatus(statusStr)
+		if !status.Valid() {
+			return nil, errors.New("Invalid status")
+		}
+		filter.Status = &status
+	}
+
+	if sinceStr := params.Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			return nil, errors.New("Invalid since timestamp")
+		}
+		filter.CreatedSince = &since
+	}
+
+	if reporterStr := params.Get("reporter"); reporterStr != "" {
+		reporterID, err := uuid.Parse(reporterStr)
+		if err != nil {
+			return nil, errors.New("Invalid reporter ID")
+		}
+		filter.ReporterID = &reporterID
+	}
+
+	return filter, nil
+}
+
+// parseBBox parses "minLon,minLat,maxLon,maxLat". A minLon greater than
+// maxLon is a box crossing the antimeridian.
+func parseBBox(s string) (*models.BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.New("Invalid bbox coordinate")
+		}
+		coords[i] = v
+	}
+
+	bbox := &models.BoundingBox{MinLon: coords[0], MinLat: coords[1], MaxLon: coords[2], MaxLat: coords[3]}
+	if bbox.MinLon < -180 || bbox.MinLon > 180 || bbox.MaxLon < -180 || bbox.MaxLon > 180 ||
+		bbox.MinLat < -90 || bbox.MaxLat > 90 || bbox.MinLon == bbox.MaxLon || bbox.MinLat >= bbox.MaxLat {
+		return nil, errors.New("Invalid bbox")
+	}
+
+	return bbox, nil
+}
+
+// validateHazardCreate checks a report before it reaches the database.
+// Errors carry the message to send back to the client.
+func validateHazardCreate(req *models.HazardCreate) error {
+	if !req.Type.Valid() {
+		return errors.New("Invalid hazard type")
+	}
+	if !req.Severity.Valid() {
+		return errors.New("Invalid severity")
+	}
+	if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 {
+		return errors.New("Invalid coordinates")
+	}
+	if req.Description != nil && len(*req.Description) > 500 {
+		return errors.New("Description must be at most 500 characters")
+	}
+	if req.Bearing != nil && (*req.Bearing < 0 || *req.Bearing >= 360) {
+		return errors.New("Invalid bearing")
+	}
+	if req.Lane != nil && !req.Lane.Valid() {
+		return errors.New("Invalid lane")
+	}
+	return nil
+}
+
+func validateBatchItem(item *models.HazardBatchItem) error {
+	if item.IdempotencyKey == "" || len(item.IdempotencyKey) > 100 {
+		return errors.New("idempotency_key is required and must be at most 100 characters")
+	}
+	if item.CapturedAt != nil && item.CapturedAt.After(time.Now().Add(maxCaptureSkew)) {
+		return errors.New("captured_at is in the future")
+	}
+	return validateHazardCreate(&item.HazardCreate)
+}
+
+func newHazard(userID uuid.UUID, req *models.HazardCreate) *models.Hazard {
+	return &models.Hazard{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Type:        req.Type,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
+		Severity:    req.Severity,
+		Description: req.Description,
+		Bearing:     req.Bearing,
+		Lane:        req.Lane,
+		IsVerified:  false,
+		VerifyCount: 0,
+	}
+}
+
+func validateHazardUpdate(req *models.HazardUpdate) error {
+	if req.Type != nil && !req.Type.Valid() {
+		return errors.New("Invalid hazard type")
+	}
+	if req.Severity != nil && !req.Severity.Valid() {
+		return errors.New("Invalid severity")
+	}
+	if req.Description != nil && len(*req.Description) > 500 {
+		return errors.New("Description must be at most 500 characters")
+	}
+	if req.Bearing != nil && (*req.Bearing < 0 || *req.Bearing >= 360) {
+		return errors.New("Invalid bearing")
+	}
+	if req.Lane != nil && !req.Lane.Valid() {
+		return errors.New("Invalid lane")
+	}
+	if req.Status != nil && !req.Status.Valid() {
+		return errors.New("Invalid status")
+	}
+	return nil
+}
+
+// hazardETag versions a hazard by its updated_at, at the microsecond
+// precision Postgres stores.
+func hazardETag(hazard *models.Hazard) string {
+	return `"` + strconv.FormatInt(hazard.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+func parseHazardETag(etag string) (time.Time, error) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return time.Time{}, errors.New("invalid etag")
+	}
+	micros, err := strconv.ParseInt(etag[1:len(etag)-1], 36, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(micros), nil
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roadeye/backend/pkg/models"
+)
+
+func TestAlongRouteRejects(t *testing.T) {
+	h := &HazardHandler{}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `{`},
+		{"no route", `{}`},
+		{"both forms", `{"polyline": "_p~iF~ps|U_ulLnnqC", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1]]}}`},
+		{"bad polyline", `{"polyline": "_p~iF"}`},
+		{"not a LineString", `{"geometry": {"type": "Point", "coordinates": [[0, 0], [1, 1]]}}`},
+		{"single point", `{"geometry": {"type": "LineString", "coordinates": [[0, 0]]}}`},
+		{"latitude out of range", `{"geometry": {"type": "LineString", "coordinates": [[0, 0], [0, 91]]}}`},
+		{"longitude out of range", `{"geometry": {"type": "LineString", "coordinates": [[0, 0], [181, 0]]}}`},
+		{"corridor too narrow", `{"polyline": "_p~iF~ps|U_ulLnnqC", "corridor_m": 0.5}`},
+		{"corridor too wide", `{"polyline": "_p~iF~ps|U_ulLnnqC", "corridor_m": 501}`},
+		{"limit too low", `{"polyline": "_p~iF~ps|U_ulLnnqC", "limit": 0}`},
+		{"unknown type", `{"polyline": "_p~iF~ps|U_ulLnnqC", "type": ["meteor"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/hazards/route", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			h.AlongRoute(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, http.StatusBadRequest, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		name    string
+		bbox    string
+		want    models.BoundingBox
+		wantErr bool
+	}{
+		{name: "ordinary", bbox: "-122.5,37.7,-122.3,37.8", want: models.BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.3, MaxLat: 37.8}},
+		{name: "spaces", bbox: " 1, 2 , 3,4 ", want: models.BoundingBox{MinLon: 1, MinLat: 2, MaxLon: 3, MaxLat: 4}},
+		{name: "across the antimeridian", bbox: "170,-20,-170,0", want: models.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0}},
+		{name: "whole world", bbox: "-180,-90,180,90", want: models.BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}},
+		{name: "three values", bbox: "1,2,3", wantErr: true},
+		{name: "not a number", bbox: "a,2,3,4", wantErr: true},
+		{name: "zero width", bbox: "10,0,10,1", wantErr: true},
+		{name: "latitudes reversed", bbox: "0,10,1,5", wantErr: true},
+		{name: "longitude out of range", bbox: "-181,0,10,1", wantErr: true},
+		{name: "latitude out of range", bbox: "0,-91,1,1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBBox(tt.bbox)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseBBox() = %+v, want an error", *got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBBox() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseBBox() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/hazards"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+type ModerationHandler struct {
+	repo     *hazards.Repository
+	accounts *auth.AccountStore
+	bus      *events.Bus
+}
+
+func NewModerationHandler(repo *hazards.Repository, accounts *auth.AccountStore, bus *events.Bus) *ModerationHandler {
+	return &ModerationHandler{repo: repo, accounts: accounts, bus: bus}
+}
+
+// Queue lists flagged hazards, heaviest open flag weight first.
+func (h *ModerationHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	limit := hazards.DefaultQueueLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > hazards.MaxQueueLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	offset := 0
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	items, err := h.repo.ModerationQueue(r.Context(), limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch moderation queue", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+// Act resolves the open flags on a hazard with a moderator decision.
+func (h *ModerationHandler) Act(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	hazardID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid hazard ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ModerationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !req.Action.Valid() {
+		http.Error(w, "Invalid moderation action", http.StatusBadRequest)
+		return
+	}
+	if req.Reason != nil && len(*req.Reason) > 500 {
+		http.Error(w, "Reason must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	hazard, err := h.repo.Moderate(r.Context(), hazardID, moderatorID, &req)
+	if errors.Is(err, hazards.ErrNotFound) {
+		http.Error(w, "Hazard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to apply moderation action", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Action == models.ModerationBanUser {
+		h.accounts.Invalidate(hazard.UserID)
+	}
+
+	switch req.Action {
+	case models.ModerationDelete:
+		h.publish(r.Context(), events.HazardDeleted, hazard)
+	case models.ModerationHide, models.ModerationBanUser:
+		if hazard.HiddenAt == nil {
+			h.publish(r.Context(), events.HazardHidden, hazard)
+		}
+	case models.ModerationDismiss:
+		if hazard.HiddenAt != nil {
+			if restored, err := h.repo.GetByID(r.Context(), hazardID); err == nil {
+				h.publish(r.Context(), events.HazardUpdated, restored)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Moderation action applied"})
+}
+
+// SetAccountStatus suspends, shadow-bans, bans or reinstates a user.
+func (h *ModerationHandler) SetAccountStatus(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if userID == moderatorID {
+		http.Error(w, "Cannot change your own account status", http.StatusBadRequest)
+		return
+	}
+
+	var req models.AccountStatusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateAccountStatus(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.repo.SetAccountStatus(r.Context(), userID, moderatorID, &req)
+	if errors.Is(err, hazards.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update account status", http.StatusInternalServerError)
+		return
+	}
+	h.accounts.Invalidate(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Account status updated"})
+}
+
+func (h *ModerationHandler) publish(ctx context.Context, eventType events.EventType, hazard *models.Hazard) {
+	publishHazard(ctx, h.bus, eventType, hazard)
+}
+
+func validateAccountStatus(req *models.AccountStatusUpdate) error {
+	if !req.Status.Valid() {
+		return errors.New("Invalid account status")
+	}
+	if req.Status == models.AccountStatusSuspended {
+		if req.Until == nil || !req.Until.After(time.Now()) {
+			return errors.New("Suspension requires a future until timestamp")
+		}
+	} else if req.Until != nil {
+		return errors.New("until only applies to suspensions")
+	}
+	if req.Reason != nil && len(*req.Reason) > 500 {
+		return errors.New("Reason must be at most 500 characters")
+	}
+	return nil
+}
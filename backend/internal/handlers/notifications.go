@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/notifications"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+type NotificationHandler struct {
+	repo *notifications.Repository
+}
+
+func NewNotificationHandler(repo *notifications.Repository) *NotificationHandler {
+	return &NotificationHandler{repo: repo}
+}
+
+// List returns the caller's inbox, newest first, with the unread count.
+// It takes ?cursor=, ?limit= and ?unread=true.
+func (h *NotificationHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	limit := notifications.DefaultInboxLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > notifications.MaxInboxLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := h.repo.Inbox(r.Context(), userID, params.Get("cursor"), limit, params.Get("unread") == "true")
+	if errors.Is(err, notifications.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	readAt, err := h.repo.MarkRead(r.Context(), userID, id)
+	if errors.Is(err, notifications.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to mark notification as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "read_at": readAt})
+}
+
+// MarkAllRead marks the caller's unread notifications as read. With
+// ?before=, an RFC 3339 time, only those created up to then are marked, so
+// that clients do not clear alerts they have not displayed yet.
+func (h *NotificationHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	before := time.Now()
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		var err error
+		before, err = time.Parse(time.RFC3339Nano, beforeStr)
+		if err != nil {
+			http.Error(w, "Invalid before time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	marked, err := h.repo.MarkAllRead(r.Context(), userID, before)
+	if err != nil {
+		http.Error(w, "Failed to mark notifications as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"marked": marked})
+}
+
+func (h *NotificationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), userID, id)
+	if errors.Is(err, notifications.ErrNotFound) {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete notification", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSettings returns the caller's notification settings, or the defaults
+// if none were saved.
+func (h *NotificationHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := h.repo.Settings(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to get notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// PutSettings replaces the caller's notification settings.
+func (h *NotificationHandler) PutSettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.NotificationSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateNotificationSettings(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.repo.PutSettings(r.Context(), userID, &req)
+	if errors.Is(err, notifications.ErrUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save notification settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func validateNotificationSettings(s *models.NotificationSettings) error {
+	if (s.Latitude == nil) != (s.Longitude == nil) {
+		return errors.New("Latitude and longitude must be given together")
+	}
+	if s.Latitude != nil && (*s.Latitude < -90 || *s.Latitude > 90 || *s.Longitude < -180 || *s.Longitude > 180) {
+		return errors.New("Invalid coordinates")
+	}
+	if s.RadiusKm < 0.1 || s.RadiusKm > notifications.MaxRadiusKm {
+		return errors.New("Radius must be between 0.1 and 50 km")
+	}
+	for _, t := range s.Types {
+		if !t.Valid() {
+			return errors.New("Invalid hazard type: " + string(t))
+		}
+	}
+	if s.MinSeverity == "" {
+		s.MinSeverity = models.HazardSeverityLow
+	}
+	if !s.MinSeverity.Valid() {
+		return errors.New("Invalid minimum severity")
+	}
+	if s.QuietHours != nil {
+		start, end, err := s.QuietHours.Minutes()
+		if err != nil {
+			return errors.New("Quiet hours must be given as HH:MM")
+		}
+		if start == end {
+			return errors.New("Quiet hours must not start and end at the same time")
+		}
+	}
+	if s.TimeZone == "" {
+		s.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil || s.TimeZone == "Local" {
+		return errors.New("Unknown time zone")
+	}
+	return nil
+}
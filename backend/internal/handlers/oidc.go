@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+var (
+	errEmailNotVerified = errors.New("email not verified by provider")
+	errLinkPassword     = errors.New("password needed to link identity")
+)
+
+// OIDCHandler signs users in through OpenID Connect providers and then
+// completes the login the same way as a password login.
+type OIDCHandler struct {
+	auth      *AuthHandler
+	sessions  *auth.OIDCSessions
+	providers map[string]*auth.OIDCProvider
+}
+
+func NewOIDCHandler(authHandler *AuthHandler, sessions *auth.OIDCSessions, providers ...*auth.OIDCProvider) *OIDCHandler {
+	h := &OIDCHandler{
+		auth:      authHandler,
+		sessions:  sessions,
+		providers: make(map[string]*auth.OIDCProvider),
+	}
+	for _, p := range providers {
+		h.providers[p.Name()] = p
+	}
+	return h
+}
+
+// Authorize starts a browser sign-in with PKCE. The verifier and nonce stay
+// on the server, keyed by the returned state.
+func (h *OIDCHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	state, session, err := h.sessions.Start(r.Context(), provider.Name())
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, session.Nonce, session.Verifier)
+	if err != nil {
+		log.Printf("OIDC discovery for %s failed: %v", provider.Name(), err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.OIDCAuthorization{
+		AuthorizationURL: authURL,
+		State:            state,
+	})
+}
+
+// Callback finishes a browser sign-in with the code and state the provider
+// redirected back with.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.OIDCCallback
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.State == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.sessions.Take(r.Context(), req.State)
+	if errors.Is(err, auth.ErrOIDCSessionUnknown) || (err == nil && session.Provider != provider.Name()) {
+		http.Error(w, "Invalid or expired sign-in session", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to complete sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), req.Code, session.Verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", provider.Name(), err)
+		http.Error(w, "Failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+
+	identity, ok := h.verify(w, r, provider, rawIDToken, session.Nonce)
+	if !ok {
+		return
+	}
+	h.signIn(w, r, provider, identity, req.Password)
+}
+
+// Token signs in with an ID token obtained by a native app. The app must
+// have asked the provider for the token with a fresh nonce, which is sent
+// along and accepted only once.
+func (h *OIDCHandler) Token(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.OIDCTokenExchange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IDToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Nonce == "" {
+		http.Error(w, "Nonce is required", http.StatusBadRequest)
+		return
+	}
+
+	identity, ok := h.verify(w, r, provider, req.IDToken, req.Nonce)
+	if !ok {
+		return
+	}
+
+	err := h.sessions.UseNonce(r.Context(), provider.Name(), req.Nonce, identity.ExpiresAt)
+	if errors.Is(err, auth.ErrIDTokenReplayed) {
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	h.signIn(w, r, provider, identity, req.Password)
+}
+
+// verify checks an ID token, writing the error response if it is refused.
+func (h *OIDCHandler) verify(w http.ResponseWriter, r *http.Request, provider *auth.OIDCProvider, rawIDToken, nonce string) (*auth.OIDCIdentity, bool) {
+	identity, err := provider.VerifyIDToken(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		log.Printf("Rejected %s ID token: %v", provider.Name(), err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return nil, false
+	}
+	return identity, true
+}
+
+func (h *OIDCHandler) signIn(w http.ResponseWriter, r *http.Request, provider *auth.OIDCProvider, identity *auth.OIDCIdentity, password string) {
+	user, status, twoFactorEnabled, err := h.linkIdentity(r.Context(), provider.Name(), identity, password)
+	if errors.Is(err, errEmailNotVerified) {
+		http.Error(w, "Email not verified by identity provider", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errLinkPassword) {
+		http.Error(w, "An account with this email exists; sign in again with its password to link it", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	h.auth.completeLogin(w, user, status, twoFactorEnabled)
+}
+
+// linkIdentity returns the user for a provider identity. An identity seen
+// for the first time is linked to the user with the same email, or to a new
+// user without a password. Emails are not verified at registration, so a
+// user with a password is only linked once password proves it is theirs;
+// otherwise whoever registered the email first would share the account.
+func (h *OIDCHandler) linkIdentity(ctx context.Context, provider string, identity *auth.OIDCIdentity, password string) (*models.User, models.AccountStatus, bool, error) {
+	tx, err := h.auth.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer tx.Rollback()
+
+	user, status, twoFactorEnabled, err := scanLoginUser(tx.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.email, u.points, u.role, u.avatar, u.status, u.totp_enabled_at, u.created_at, u.updated_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`, provider, identity.Subject))
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP
+			WHERE provider = $1 AND subject = $2
+		`, provider, identity.Subject)
+		if err != nil {
+			return nil, "", false, err
+		}
+		return user, status, twoFactorEnabled, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, "", false, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, "", false, errEmailNotVerified
+	}
+
+	var userID uuid.UUID
+	var passwordHash string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, password_hash FROM users WHERE LOWER(email) = LOWER($1)
+		ORDER BY created_at LIMIT 1
+		FOR UPDATE
+	`, identity.Email).Scan(&userID, &passwordHash)
+	if err == sql.ErrNoRows {
+		user, err = createIdentityUser(ctx, tx, identity.Email)
+		status = models.AccountStatusActive
+	} else if err == nil {
+		if passwordHash != "" {
+			if ok, _ := h.auth.passwords.Verify(password, passwordHash); !ok {
+				return nil, "", false, errLinkPassword
+			}
+		}
+		user, status, twoFactorEnabled, err = scanLoginUser(tx.QueryRowContext(ctx, `
+			SELECT id, username, email, points, role, avatar, status, totp_enabled_at, created_at, updated_at
+			FROM users WHERE id = $1
+		`, userID))
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO NOTHING
+	`, user.ID, provider, identity.Subject, identity.Email)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return user, status, twoFactorEnabled, tx.Commit()
+}
+
+func scanLoginUser(row *sql.Row) (*models.User, models.AccountStatus, bool, error) {
+	user := &models.User{}
+	var status models.AccountStatus
+	var totpEnabledAt sql.NullTime
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Email, &user.Points, &user.Role,
+		&user.Avatar, &status, &totpEnabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, "", false, err
+	}
+	return user, status, totpEnabledAt.Valid, nil
+}
+
+// createIdentityUser creates a passwordless user named after the local part
+// of email.
+func createIdentityUser(ctx context.Context, tx *sql.Tx, email string) (*models.User, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:       uuid.New(),
+		Username: usernameFromEmail(email) + "_" + hex.EncodeToString(suffix),
+		Email:    email,
+		Role:     models.UserRoleUser,
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO users (id, username, email, password_hash, points)
+		VALUES ($1, $2, $3, '', 0)
+		RETURNING created_at, updated_at
+	`, user.ID, user.Username, user.Email).Scan(&user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func usernameFromEmail(email string) string {
+	local := strings.ToLower(email)
+	if i := strings.Index(local, "@"); i >= 0 {
+		local = local[:i]
+	}
+
+	var b strings.Builder
+	for _, c := range local {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' {
+			b.WriteRune(c)
+		}
+		if b.Len() == 30 {
+			break
+		}
+	}
+	if b.Len() < 3 {
+		return "user"
+	}
+	return b.String()
+}
+
+func (h *OIDCHandler) provider(w http.ResponseWriter, r *http.Request) (*auth.OIDCProvider, bool) {
+	provider, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+	}
+	return provider, ok
+}
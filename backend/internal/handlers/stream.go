@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/hazards"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+type StreamHandler struct {
+	hub *events.Hub
+}
+
+func NewStreamHandler(hub *events.Hub) *StreamHandler {
+	return &StreamHandler{hub: hub}
+}
+
+// Stream opens a Server-Sent Events feed of hazard events around lat/lon.
+// The first event carries the subscription ID used to move the area.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	area, err := parseArea(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := h.hub.Subscribe(userID, *area)
+	defer h.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	writeSSE(w, "", "subscribed", map[string]interface{}{"subscription_id": sub.ID})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Dropped():
+			writeSSE(w, "", "overflow", map[string]interface{}{"message": "Stream fell behind, reconnect to resume"})
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event := <-sub.Events():
+			writeSSE(w, event.ID.String(), string(event.Type), event)
+			flusher.Flush()
+		}
+	}
+}
+
+// UpdateArea moves the area of an open stream as the user drives.
+func (h *StreamHandler) UpdateArea(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return
+	}
+
+	var area events.Area
+	if err := json.NewDecoder(r.Body).Decode(&area); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateArea(&area); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	update := &events.AreaUpdate{SubscriptionID: subscriptionID, UserID: userID, Area: area}
+	if err := h.hub.UpdateArea(r.Context(), update); err != nil {
+		http.Error(w, "Failed to update area", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func parseArea(r *http.Request) (*events.Area, error) {
+	params := r.URL.Query()
+	area := &events.Area{RadiusKm: hazards.DefaultRadiusKm}
+
+	lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil {
+		return nil, errors.New("Invalid latitude")
+	}
+	lon, err := strconv.ParseFloat(params.Get("lon"), 64)
+	if err != nil {
+		return nil, errors.New("Invalid longitude")
+	}
+	area.Latitude, area.Longitude = lat, lon
+
+	if radiusStr := params.Get("radius"); radiusStr != "" {
+		area.RadiusKm, err = strconv.ParseFloat(radiusStr, 64)
+		if err != nil {
+			return nil, errors.New("Invalid radius")
+		}
+	}
+
+	return area, validateArea(area)
+}
+
+func validateArea(area *events.Area) error {
+	if area.Latitude < -90 || area.Latitude > 90 {
+		return errors.New("Invalid latitude")
+	}
+	if area.Longitude < -180 || area.Longitude > 180 {
+		return errors.New("Invalid longitude")
+	}
+	if area.RadiusKm < hazards.MinRadiusKm || area.RadiusKm > hazards.MaxRadiusKm {
+		return errors.New("Invalid radius")
+	}
+	return nil
+}
+
+func writeSSE(w http.ResponseWriter, id, event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
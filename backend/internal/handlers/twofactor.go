@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// SetupTwoFactor starts TOTP enrolment. The secret only takes effect once
+// EnableTwoFactor confirms a code generated from it.
+func (h *AuthHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	err = h.db.QueryRowContext(r.Context(), `
+		UPDATE users SET totp_secret = $2
+		WHERE id = $1 AND totp_enabled_at IS NULL
+		RETURNING email
+	`, userID, secret).Scan(&email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start two-factor setup", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.TwoFactorSetup{
+		Secret: secret,
+		URI:    auth.TOTPURI(email, secret),
+	})
+}
+
+// EnableTwoFactor confirms enrolment with a code from the authenticator and
+// returns the recovery codes. They are shown only this once.
+func (h *AuthHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	err := h.db.QueryRowContext(r.Context(), `SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1`, userID).
+		Scan(&secret, &enabledAt)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if enabledAt.Valid {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !secret.Valid {
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret.String, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(r.Context(), `
+		UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = $2
+		WHERE id = $1
+	`, userID, step)
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.RecoveryCodes{Codes: codes})
+}
+
+// DisableTwoFactor turns 2FA off after checking a current code. Privileged
+// roles cannot turn it off.
+func (h *AuthHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var role models.UserRole
+	if err := h.db.QueryRowContext(r.Context(), `SELECT role FROM users WHERE id = $1`, userID).Scan(&role); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if role.RequiresTwoFactor() {
+		http.Error(w, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+
+	if !h.checkSecondFactor(w, r, userID, req.Code) {
+		return
+	}
+
+	_, err := h.db.ExecContext(r.Context(), `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+		WHERE id = $1
+	`, userID)
+	if err == nil {
+		_, err = h.db.ExecContext(r.Context(), `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a
+// current code.
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkSecondFactor(w, r, userID, req.Code) {
+		return
+	}
+
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(r.Context(), tx, userID)
+	if err != nil || tx.Commit() != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&models.RecoveryCodes{Codes: codes})
+}
+
+// VerifyTwoFactor completes a challenged login with a TOTP code or a
+// recovery code. Wrong codes count as failed logins.
+func (h *AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req models.TwoFactorVerify
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.jwtManager.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	ip := clientIP(r)
+	if !h.allowLoginAttempt(w, r, claims.Email, ip) {
+		return
+	}
+
+	user := &models.User{}
+	var status models.AccountStatus
+	query := `
+		SELECT id, username, email, points, role, avatar, status, created_at, updated_at
+		FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL
+	`
+	err = h.db.QueryRowContext(r.Context(), query, claims.UserID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.Points,
+		&user.Role, &user.Avatar, &status, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+	if status == models.AccountStatusBanned {
+		http.Error(w, "Account is banned", http.StatusForbidden)
+		return
+	}
+
+	code := req.Code
+	if code == "" {
+		code = req.RecoveryCode
+	}
+	ok, err := h.consumeSecondFactor(r.Context(), user.ID, code)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.loginFailed(r, user.Email, true)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.guard.Succeed(r.Context(), user.Email, ip); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+
+	h.issueTokens(w, user, true)
+}
+
+// checkSecondFactor verifies a code for an already signed-in user, writing
+// the error response when it is refused.
+func (h *AuthHandler) checkSecondFactor(w http.ResponseWriter, r *http.Request, userID uuid.UUID, code string) bool {
+	ok, err := h.consumeSecondFactor(r.Context(), userID, code)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// consumeSecondFactor accepts a TOTP code or an unused recovery code for a
+// user with 2FA enabled. Each TOTP time step and each recovery code can be
+// used only once.
+func (h *AuthHandler) consumeSecondFactor(ctx context.Context, userID uuid.UUID, code string) (bool, error) {
+	var secret sql.NullString
+	err := h.db.QueryRowContext(ctx, `SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL`, userID).
+		Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := auth.ValidateTOTP(secret.String, code, time.Now()); ok {
+		result, err := h.db.ExecContext(ctx, `
+			UPDATE users SET totp_last_step = $2
+			WHERE id = $1 AND totp_last_step < $2
+		`, userID, step)
+		if err != nil {
+			return false, err
+		}
+		rows, err := result.RowsAffected()
+		return rows == 1, err
+	}
+
+	result, err := h.db.ExecContext(ctx, `
+		UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+			userID, auth.HashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
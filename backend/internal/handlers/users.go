@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/notifications"
+	"github.com/roadeye/backend/internal/users"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+type UserHandler struct {
+	repo          *users.Repository
+	passwords     *auth.PasswordManager
+	deletionGrace time.Duration
+}
+
+func NewUserHandler(repo *users.Repository, passwords *auth.PasswordManager, deletionGrace time.Duration) *UserHandler {
+	return &UserHandler{repo: repo, passwords: passwords, deletionGrace: deletionGrace}
+}
+
+// Delete schedules the account for deletion once the grace period is over.
+// Until then the account works as usual and the deletion can be cancelled.
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.AccountDeletion
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	hash, err := h.repo.PasswordHash(r.Context(), userID)
+	if errors.Is(err, users.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	if hash != "" {
+		if valid, _ := h.passwords.Verify(req.Password, hash); !valid {
+			http.Error(w, "Invalid password", http.StatusForbidden)
+			return
+		}
+	}
+
+	scheduled, err := h.repo.ScheduleDeletion(r.Context(), userID, time.Now().Add(h.deletionGrace))
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&models.DeletionSchedule{ScheduledFor: scheduled})
+}
+
+func (h *UserHandler) CancelDeletion(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cancelled, err := h.repo.CancelDeletion(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "No deletion scheduled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Account deletion cancelled"})
+}
+
+// PutPreferences sets the language and units of the caller's
+// notifications.
+func (h *UserHandler) PutPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.UserPreferences
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	locale, ok := notifications.NormalizeLocale(req.Locale)
+	if !ok {
+		http.Error(w, "Invalid locale", http.StatusBadRequest)
+		return
+	}
+	req.Locale = locale
+	if !req.Units.Valid() {
+		http.Error(w, "Units must be metric or imperial", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.SetPreferences(r.Context(), userID, &req)
+	if errors.Is(err, users.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&req)
+}
+
+// Export returns everything held about the user as a ZIP of JSON files, or
+// as one JSON document with ?format=json.
+func (h *UserHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "zip" && format != "json" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	export, err := h.repo.Export(r.Context(), userID)
+	if errors.Is(err, users.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "roadeye-export-" + export.GeneratedAt.Format("20060102")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"hazards.json", export.Hazards},
+		{"verifications.json", export.Verifications},
+		{"comments.json", export.Comments},
+		{"notifications.json", export.Notifications},
+		{"device_tokens.json", export.DeviceTokens},
+		{"location_history.json", export.LocationHistory},
+	}
+
+	archive := zip.NewWriter(w)
+	for _, f := range files {
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: export.GeneratedAt,
+		})
+		if err == nil {
+			enc := json.NewEncoder(file)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(f.data)
+		}
+		if err != nil {
+			// Headers are already sent; the truncated archive will not open
+			log.Printf("Failed to write data export: %v", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to write data export: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/auth"
+	"github.com/roadeye/backend/internal/geo"
+	"github.com/roadeye/backend/internal/webhooks"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// maxWebhookVertices bounds the size of a subscription's polygon.
+const maxWebhookVertices = 1000
+
+// WebhookHandler manages the caller's own webhook subscriptions.
+type WebhookHandler struct {
+	repo *webhooks.Repository
+}
+
+func NewWebhookHandler(repo *webhooks.Repository) *WebhookHandler {
+	return &WebhookHandler{repo: repo}
+}
+
+// Create adds a subscription. The response is the only time the signing
+// secret is shown.
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.WebhookCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateWebhookFilters(&req.URL, req.Types, req.MinSeverity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.BBox == nil && req.Polygon == nil {
+		http.Error(w, "Either bbox or polygon is required", http.StatusBadRequest)
+		return
+	}
+	area, err := webhookArea(req.BBox, req.Polygon)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub, err := h.repo.Create(r.Context(), userID, &req, area)
+	if errors.Is(err, webhooks.ErrInvalidArea) {
+		http.Error(w, "Polygon is not valid", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, webhooks.ErrLimitReached) {
+		http.Error(w, "Webhook limit reached", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subs, err := h.repo.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"webhooks": subs})
+}
+
+func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.WebhookUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validateWebhookFilters(req.URL, req.Types, req.MinSeverity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var area [][]geo.Point
+	if req.BBox != nil || req.Polygon != nil {
+		if area, err = webhookArea(req.BBox, req.Polygon); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub, err := h.repo.Update(r.Context(), id, userID, &req, area)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, webhooks.ErrInvalidArea) {
+		http.Error(w, "Polygon is not valid", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sub)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.repo.Delete(r.Context(), id, userID)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the delivery log, newest first. It takes ?status=,
+// ?limit= and ?offset=.
+func (h *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	var status *models.WebhookDeliveryStatus
+	if s := params.Get("status"); s != "" {
+		st := models.WebhookDeliveryStatus(s)
+		if !st.Valid() {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		status = &st
+	}
+
+	limit := webhooks.DefaultDeliveryLimit
+	if limitStr := params.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > webhooks.MaxDeliveryLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	offset := 0
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := h.repo.Deliveries(r.Context(), sub.ID, status, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries})
+}
+
+// Redeliver queues the payload of an earlier delivery again. The new
+// delivery carries the same event ID, so receivers can deduplicate.
+func (h *WebhookHandler) Redeliver(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.subscription(w, r)
+	if !ok {
+		return
+	}
+	if sub.DisabledAt != nil {
+		http.Error(w, "Webhook is disabled", http.StatusConflict)
+		return
+	}
+
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := h.repo.Redeliver(r.Context(), sub.ID, deliveryID)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to redeliver", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// subscription loads the {id} subscription of the caller, writing the
+// error response if there is none.
+func (h *WebhookHandler) subscription(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	userID, ok := auth.GetUserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	sub, err := h.repo.Get(r.Context(), id, userID)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to get webhook", http.StatusInternalServerError)
+		return nil, false
+	}
+	return sub, true
+}
+
+func validateWebhookFilters(rawURL *string, types []models.HazardType, minSeverity *models.HazardSeverity) error {
+	if rawURL != nil {
+		u, err := url.Parse(*rawURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || len(*rawURL) > 2048 {
+			return errors.New("URL must be an https URL")
+		}
+	}
+	for _, t := range types {
+		if !t.Valid() {
+			return errors.New("Invalid hazard type: " + string(t))
+		}
+	}
+	if minSeverity != nil && !minSeverity.Valid() {
+		return errors.New("Invalid minimum severity")
+	}
+	return nil
+}
+
+// webhookArea turns a bbox or a GeoJSON polygon into closed rings. Whether
+// the rings intersect themselves is left to the database.
+func webhookArea(bbox *models.BoundingBox, polygon *models.GeoJSONPolygon) ([][]geo.Point, error) {
+	if bbox != nil && polygon != nil {
+		return nil, errors.New("Give either bbox or polygon, not both")
+	}
+
+	if bbox != nil {
+		if bbox.MinLon < -180 || bbox.MaxLon > 180 || bbox.MinLat < -90 || bbox.MaxLat > 90 ||
+			bbox.MinLon >= bbox.MaxLon || bbox.MinLat >= bbox.MaxLat {
+			return nil, errors.New("Invalid bbox")
+		}
+		return [][]geo.Point{geo.BBoxRing(bbox.MinLon, bbox.MinLat, bbox.MaxLon, bbox.MaxLat)}, nil
+	}
+
+	if polygon.Type != "Polygon" || len(polygon.Coordinates) == 0 {
+		return nil, errors.New("polygon must be a GeoJSON Polygon")
+	}
+	rings := make([][]geo.Point, len(polygon.Coordinates))
+	vertices := 0
+	for i, ring := range polygon.Coordinates {
+		if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+			return nil, errors.New("Polygon rings must be closed with at least 4 positions")
+		}
+		vertices += len(ring)
+		if vertices > maxWebhookVertices {
+			return nil, errors.New("Polygon has too many vertices")
+		}
+		rings[i] = make([]geo.Point, len(ring))
+		for j, c := range ring {
+			if c[0] < -180 || c[0] > 180 || c[1] < -90 || c[1] > 90 {
+				return nil, errors.New("Invalid polygon coordinate")
+			}
+			rings[i][j] = geo.Point{Lat: c[1], Lon: c[0]}
+		}
+	}
+	return rings, nil
+}
@@ -0,0 +1,81 @@
+package hazards
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordEvent appends to a hazard's audit history. Callers pass the
+// transaction making the change so the history never disagrees with it.
+// A nil actorID records an automatic action taken by the system.
+func recordEvent(ctx context.Context, ex execer, hazardID, actorID uuid.UUID, action models.HazardAction, data map[string]interface{}) error {
+	var actor interface{}
+	if actorID != uuid.Nil {
+		actor = actorID
+	}
+
+	var payload []byte
+	if data != nil {
+		var err error
+		if payload, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO hazard_events (hazard_id, actor_id, action, data)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := ex.ExecContext(ctx, query, hazardID, actor, action, payload)
+	return err
+}
+
+// History returns every audit event for a hazard, oldest first, including
+// events for hazards that have since been deleted.
+func (r *Repository) History(ctx context.Context, hazardID uuid.UUID) ([]*models.HazardEvent, error) {
+	query := `
+		SELECT e.id, e.hazard_id, e.actor_id, u.username, e.action, e.data, e.created_at
+		FROM hazard_events e
+		LEFT JOIN users u ON u.id = e.actor_id
+		WHERE e.hazard_id = $1
+		ORDER BY e.created_at ASC, e.id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, hazardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*models.HazardEvent{}
+	for rows.Next() {
+		event := &models.HazardEvent{}
+		var data []byte
+
+		err := rows.Scan(
+			&event.ID, &event.HazardID, &event.ActorID, &event.ActorUsername,
+			&event.Action, &data, &event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if data != nil {
+			if err := json.Unmarshal(data, &event.Data); err != nil {
+				return nil, err
+			}
+		}
+
+		history = append(history, event)
+	}
+
+	return history, rows.Err()
+}
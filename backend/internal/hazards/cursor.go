@@ -0,0 +1,72 @@
+package hazards
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the keyset position of the last hazard on a page. It is handed to
+// clients as an opaque string and only means something for the sort order it
+// was issued with.
+type Cursor struct {
+	Sort         models.HazardSort `json:"s"`
+	Distance     float64           `json:"d,omitempty"`
+	SeverityRank int               `json:"r,omitempty"`
+	CreatedAt    time.Time         `json:"t"`
+	ID           uuid.UUID         `json:"i"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string, sort models.HazardSort) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sort || c.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// ChangeCursor is a position in the hazard change log. Changes are ordered by
+// transaction ID first so a change committed late is never skipped.
+type ChangeCursor struct {
+	TxID int64 `json:"x"`
+	Seq  int64 `json:"q"`
+}
+
+func (c ChangeCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeChangeCursor(s string) (*ChangeCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c ChangeCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
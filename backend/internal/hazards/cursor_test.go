@@ -0,0 +1,87 @@
+package hazards
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	id := uuid.MustParse("5f0c2a8e-7b1d-4c3e-9a6f-2d8b4e1c0a97")
+
+	tests := []Cursor{
+		{Sort: models.HazardSortDistance, Distance: 1234.5, CreatedAt: createdAt, ID: id},
+		{Sort: models.HazardSortRecent, CreatedAt: createdAt, ID: id},
+		{Sort: models.HazardSortSeverity, SeverityRank: 3, CreatedAt: createdAt, ID: id},
+	}
+	for _, want := range tests {
+		t.Run(string(want.Sort), func(t *testing.T) {
+			got, err := DecodeCursor(want.Encode(), want.Sort)
+			if err != nil {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+			if got.Sort != want.Sort || got.Distance != want.Distance || got.SeverityRank != want.SeverityRank ||
+				!got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+				t.Errorf("DecodeCursor() = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	recent := Cursor{Sort: models.HazardSortRecent, CreatedAt: time.Now(), ID: uuid.New()}
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   models.HazardSort
+	}{
+		{"empty", "", models.HazardSortRecent},
+		{"not base64", "not a cursor!", models.HazardSortRecent},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("nope")), models.HazardSortRecent},
+		{"other sort", recent.Encode(), models.HazardSortSeverity},
+		{"no ID", Cursor{Sort: models.HazardSortRecent}.Encode(), models.HazardSortRecent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCursor(tt.cursor, tt.sort); err != ErrInvalidCursor {
+				t.Errorf("DecodeCursor() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestChangeCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cursor  string
+		want    ChangeCursor
+		wantErr bool
+	}{
+		{"round trip", ChangeCursor{TxID: 987654321, Seq: 42}.Encode(), ChangeCursor{TxID: 987654321, Seq: 42}, false},
+		{"zero", ChangeCursor{}.Encode(), ChangeCursor{}, false},
+		{"not base64", "%%%", ChangeCursor{}, true},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("[1,2]")), ChangeCursor{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeChangeCursor(tt.cursor)
+			if tt.wantErr {
+				if err != ErrInvalidCursor {
+					t.Errorf("DecodeChangeCursor() error = %v, want ErrInvalidCursor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeChangeCursor() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("DecodeChangeCursor() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package hazards
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	// AutoHideWeight is the total weight of open flags at which a hazard is
+	// hidden until a moderator reviews it.
+	AutoHideWeight = 6
+
+	DefaultQueueLimit = 50
+	MaxQueueLimit     = 100
+)
+
+var (
+	ErrAlreadyFlagged = errors.New("hazard already flagged by user")
+	ErrUserNotFound   = errors.New("user not found")
+)
+
+// flagWeights scores flag reasons by how harmful the content would be if the
+// flag is right.
+var flagWeights = map[models.FlagReason]int{
+	models.FlagReasonFake:           3,
+	models.FlagReasonOffensiveImage: 3,
+	models.FlagReasonSpam:           2,
+	models.FlagReasonDuplicate:      1,
+}
+
+// Flag records a user's flag on a hazard and hides the hazard once its open
+// flags reach AutoHideWeight. hidden reports whether this flag hid it. Flags
+// from shadow-banned users are kept but weigh nothing.
+func (r *Repository) Flag(ctx context.Context, hazardID, userID uuid.UUID, req *models.FlagCreate) (hidden bool, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var hiddenAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `SELECT hidden_at FROM hazards WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, hazardID).
+		Scan(&hiddenAt)
+	if err == sql.ErrNoRows {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO hazard_flags (hazard_id, user_id, reason, details, weight)
+		SELECT $1, $2, $3, $4, CASE WHEN status = 'shadow_banned' THEN 0 ELSE $5 END
+		FROM users WHERE id = $2
+		ON CONFLICT (hazard_id, user_id) DO NOTHING
+	`
+	result, err := tx.ExecContext(ctx, query, hazardID, userID, req.Reason, req.Details, flagWeights[req.Reason])
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return false, err
+	} else if rows == 0 {
+		return false, ErrAlreadyFlagged
+	}
+
+	data := map[string]interface{}{"reason": req.Reason}
+	if err := recordEvent(ctx, tx, hazardID, userID, models.HazardActionFlagged, data); err != nil {
+		return false, err
+	}
+
+	if !hiddenAt.Valid {
+		var weight int
+		err = tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(weight), 0) FROM hazard_flags WHERE hazard_id = $1 AND resolved_at IS NULL`, hazardID).
+			Scan(&weight)
+		if err != nil {
+			return false, err
+		}
+
+		if weight >= AutoHideWeight {
+			data := map[string]interface{}{"reason": "flag threshold reached", "flag_weight": weight}
+			if err := setHidden(ctx, tx, hazardID, uuid.Nil, true, data); err != nil {
+				return false, err
+			}
+			hidden = true
+		}
+	}
+
+	return hidden, tx.Commit()
+}
+
+// ModerationQueue lists hazards with open flags, heaviest first.
+func (r *Repository) ModerationQueue(ctx context.Context, limit, offset int) ([]*models.ModerationItem, error) {
+	query := `
+		SELECT ` + hazardColumns + `, q.flag_weight, q.flag_count
+		FROM hazards
+		JOIN (
+			SELECT hazard_id, SUM(weight) AS flag_weight, COUNT(*) AS flag_count, MAX(created_at) AS last_flagged_at
+			FROM hazard_flags
+			WHERE resolved_at IS NULL
+			GROUP BY hazard_id
+		) q ON q.hazard_id = hazards.id
+		WHERE deleted_at IS NULL
+		ORDER BY q.flag_weight DESC, q.last_flagged_at DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*models.ModerationItem{}
+	byHazard := make(map[uuid.UUID]*models.ModerationItem)
+	var ids []uuid.UUID
+	for rows.Next() {
+		item := &models.ModerationItem{Flags: []*models.HazardFlag{}}
+
+		hazard, err := scanHazard(rows, &item.FlagWeight, &item.FlagCount)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Hazard = hazard
+		items = append(items, item)
+		byHazard[hazard.ID] = item
+		ids = append(ids, hazard.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return items, nil
+	}
+
+	flagRows, err := r.db.QueryContext(ctx, `
+		SELECT id, hazard_id, user_id, reason, details, weight, created_at
+		FROM hazard_flags
+		WHERE hazard_id = ANY($1) AND resolved_at IS NULL
+		ORDER BY created_at ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer flagRows.Close()
+
+	for flagRows.Next() {
+		flag := &models.HazardFlag{}
+		err := flagRows.Scan(&flag.ID, &flag.HazardID, &flag.UserID, &flag.Reason, &flag.Details, &flag.Weight, &flag.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		byHazard[flag.HazardID].Flags = append(byHazard[flag.HazardID].Flags, flag)
+	}
+
+	return items, flagRows.Err()
+}
+
+// Moderate applies a moderator's decision to a flagged hazard, resolves its
+// open flags and records the action. ModerationBanUser bans the hazard's
+// reporter. The hazard is returned as it was before the action.
+func (r *Repository) Moderate(ctx context.Context, hazardID, moderatorID uuid.UUID, req *models.ModerationRequest) (*models.Hazard, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + hazardColumns + ` FROM hazards WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	hazard, err := scanHazard(tx.QueryRowContext(ctx, query, hazardID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if req.Reason != nil {
+		data = map[string]interface{}{"reason": *req.Reason}
+	}
+
+	switch req.Action {
+	case models.ModerationDismiss:
+		if hazard.HiddenAt != nil {
+			if err := setHidden(ctx, tx, hazardID, moderatorID, false, data); err != nil {
+				return nil, err
+			}
+		}
+	case models.ModerationHide:
+		if hazard.HiddenAt == nil {
+			if err := setHidden(ctx, tx, hazardID, moderatorID, true, data); err != nil {
+				return nil, err
+			}
+		}
+	case models.ModerationDelete:
+		if err := softDelete(ctx, tx, hazardID, moderatorID, req.Reason); err != nil {
+			return nil, err
+		}
+	case models.ModerationBanUser:
+		if err := setAccountStatus(ctx, tx, hazard.UserID, models.AccountStatusBanned, nil, req.Reason); err != nil {
+			return nil, err
+		}
+		if hazard.HiddenAt == nil {
+			if err := setHidden(ctx, tx, hazardID, moderatorID, true, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE hazard_flags SET resolved_at = CURRENT_TIMESTAMP, resolved_by = $2, resolution = $3
+		WHERE hazard_id = $1 AND resolved_at IS NULL
+	`, hazardID, moderatorID, req.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO moderation_actions (moderator_id, hazard_id, target_user_id, action, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, moderatorID, hazardID, hazard.UserID, req.Action, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return hazard, tx.Commit()
+}
+
+func setHidden(ctx context.Context, ex execer, hazardID, actorID uuid.UUID, hidden bool, data map[string]interface{}) error {
+	query := `UPDATE hazards SET hidden_at = CURRENT_TIMESTAMP WHERE id = $1`
+	action := models.HazardActionHidden
+	if !hidden {
+		query = `UPDATE hazards SET hidden_at = NULL WHERE id = $1`
+		action = models.HazardActionUnhidden
+	}
+
+	if _, err := ex.ExecContext(ctx, query, hazardID); err != nil {
+		return err
+	}
+	return recordEvent(ctx, ex, hazardID, actorID, action, data)
+}
+
+// SetAccountStatus changes a user's account state and records the moderator
+// action. Shadow-banning shadows the user's existing hazards as well as new
+// ones; any other state unshadows them.
+func (r *Repository) SetAccountStatus(ctx context.Context, userID, moderatorID uuid.UUID, req *models.AccountStatusUpdate) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setAccountStatus(ctx, tx, userID, req.Status, req.Until, req.Reason); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO moderation_actions (moderator_id, target_user_id, action, reason)
+		VALUES ($1, $2, $3, $4)
+	`, moderatorID, userID, "set_"+string(req.Status), req.Reason)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setAccountStatus(ctx context.Context, ex execer, userID uuid.UUID, status models.AccountStatus, until *time.Time, reason *string) error {
+	result, err := ex.ExecContext(ctx, `
+		UPDATE users SET status = $2, suspended_until = $3, status_reason = $4
+		WHERE id = $1
+	`, userID, status, until, reason)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	shadowed := status == models.AccountStatusShadowBanned
+	_, err = ex.ExecContext(ctx, `
+		UPDATE hazards SET shadowed = $2
+		WHERE user_id = $1 AND deleted_at IS NULL AND shadowed <> $2
+	`, userID, shadowed)
+	return err
+}
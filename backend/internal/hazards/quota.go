@@ -0,0 +1,103 @@
+package hazards
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	// NewAccountAge is how long an account counts as new.
+	NewAccountAge = 7 * 24 * time.Hour
+	// TrustedVerifiedReports is how many verified reports earn the trusted
+	// level.
+	TrustedVerifiedReports = 20
+	// QuotaWindow is the rolling period report quotas apply to.
+	QuotaWindow = 24 * time.Hour
+)
+
+var ErrQuotaExceeded = errors.New("daily report quota exceeded")
+
+// reportQuotas caps reports per QuotaWindow by trust level. Staff are not
+// limited.
+var reportQuotas = map[models.TrustLevel]int{
+	models.TrustLevelNew:     5,
+	models.TrustLevelRegular: 25,
+	models.TrustLevelTrusted: 100,
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Quota returns the user's report allowance for the current window.
+func (r *Repository) Quota(ctx context.Context, userID uuid.UUID) (*models.ReportQuota, error) {
+	quota, _, err := loadQuota(ctx, r.db, userID, false)
+	return quota, err
+}
+
+// loadQuota computes the user's trust level and reports used in the current
+// window, and whether their new reports must be shadowed. With lock set the
+// user row stays locked until the transaction ends, so concurrent reports by
+// the same user are counted one at a time.
+func loadQuota(ctx context.Context, q queryRower, userID uuid.UUID, lock bool) (*models.ReportQuota, bool, error) {
+	query := `
+		SELECT u.role, u.status, u.created_at,
+		       (SELECT COUNT(*) FROM hazards WHERE user_id = u.id AND is_verified),
+		       (SELECT COUNT(*) FROM hazards WHERE user_id = u.id AND created_at > $2)
+		FROM users u
+		WHERE u.id = $1
+	`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	var role models.UserRole
+	var status models.AccountStatus
+	var createdAt time.Time
+	var verified int
+	quota := &models.ReportQuota{}
+
+	err := q.QueryRowContext(ctx, query, userID, time.Now().Add(-QuotaWindow)).
+		Scan(&role, &status, &createdAt, &verified, &quota.Used)
+	if err != nil {
+		return nil, false, err
+	}
+
+	quota.TrustLevel = trustLevel(role, createdAt, verified)
+	quota.Limit = reportQuotas[quota.TrustLevel]
+
+	return quota, status == models.AccountStatusShadowBanned, nil
+}
+
+func trustLevel(role models.UserRole, createdAt time.Time, verified int) models.TrustLevel {
+	switch {
+	case role != models.UserRoleUser:
+		return models.TrustLevelStaff
+	case verified >= TrustedVerifiedReports:
+		return models.TrustLevelTrusted
+	case time.Since(createdAt) < NewAccountAge:
+		return models.TrustLevelNew
+	default:
+		return models.TrustLevelRegular
+	}
+}
+
+// reserveReport checks the reporter's quota inside the inserting
+// transaction and marks the hazard shadowed if the reporter is shadow-banned.
+func reserveReport(ctx context.Context, tx *sql.Tx, hazard *models.Hazard) error {
+	quota, shadowed, err := loadQuota(ctx, tx, hazard.UserID, true)
+	if err != nil {
+		return err
+	}
+	if quota.Limit > 0 && quota.Used >= quota.Limit {
+		return ErrQuotaExceeded
+	}
+
+	hazard.Shadowed = shadowed
+	return nil
+}
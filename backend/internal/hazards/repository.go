@@ -3,12 +3,152 @@ package hazards
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/internal/geo"
 	"github.com/roadeye/backend/pkg/models"
 )
 
+const (
+	DefaultRadiusKm = 5.0
+	MaxRadiusKm     = 50.0
+	MinRadiusKm     = 0.1
+	DefaultLimit    = 50
+	MaxLimit        = 100
+
+	DefaultCorridorM  = 50.0
+	MaxCorridorM      = 500.0
+	DefaultRouteLimit = 200
+	MaxRouteLimit     = 500
+	MaxRoutePoints    = 5000
+
+	// Drive mode looks LookAheadSeconds of travel ahead of the vehicle,
+	// bounded by the min and max look-ahead distances.
+	LookAheadSeconds      = 30.0
+	MinLookAheadM         = 200.0
+	MaxLookAheadM         = 3000.0
+	DefaultToleranceDeg   = 30.0
+	DirectionToleranceDeg = 60.0
+	DriveLimit            = 50
+
+	MaxBatchSize = 100
+
+	// EditWindow is how long reporters may edit their own hazards.
+	// Moderators may edit at any time.
+	EditWindow = time.Hour
+
+	DefaultChangesLimit = 500
+	MaxChangesLimit     = 1000
+
+	// ClusterThreshold is the most hazards a viewport query returns as
+	// individual markers before it switches to grid clusters.
+	ClusterThreshold = 300
+	// ClusterGridSize is the number of grid cells along the longer side of a
+	// clustered viewport.
+	ClusterGridSize = 16
+)
+
+var (
+	ErrNotFound = errors.New("hazard not found")
+	// ErrModified means the hazard changed since the version the caller read.
+	ErrModified = errors.New("hazard was modified")
+)
+
+const hazardColumns = `id, user_id, type, latitude, longitude, image_url, severity, description,
+		       is_verified, verify_count, reported_by, bearing, lane, status, client_key, captured_at,
+		       hidden_at, shadowed, created_at, updated_at`
+
+// angleDiff yields the absolute difference in degrees between two bearings,
+// folded into [0, 180].
+const angleDiff = `abs(((%s - %s + 540)::numeric %% 360) - 180)`
+
+// visible excludes hazards that were deleted or hidden by moderation.
+const visible = `deleted_at IS NULL AND hidden_at IS NULL`
+
+// unshadowed excludes shadowed hazards unless viewer, a query parameter
+// placeholder, reported them.
+func unshadowed(viewer string) string {
+	return "(NOT shadowed OR user_id = " + viewer + ")"
+}
+
+const severityRank = `CASE severity WHEN 'high' THEN 3 WHEN 'medium' THEN 2 ELSE 1 END`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanHazard scans a row selected with hazardColumns, followed by any extra
+// columns the caller appended to the select list.
+func scanHazard(row scanner, extra ...interface{}) (*models.Hazard, error) {
+	hazard := &models.Hazard{}
+	dest := []interface{}{
+		&hazard.ID, &hazard.UserID, &hazard.Type, &hazard.Latitude, &hazard.Longitude,
+		&hazard.ImageURL, &hazard.Severity, &hazard.Description, &hazard.IsVerified,
+		&hazard.VerifyCount, &hazard.ReportedBy, &hazard.Bearing, &hazard.Lane, &hazard.Status,
+		&hazard.ClientKey, &hazard.CapturedAt, &hazard.HiddenAt, &hazard.Shadowed, &hazard.CreatedAt,
+		&hazard.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return hazard, nil
+}
+
+// queryArgs collects positional parameters for dynamically built queries.
+type queryArgs []interface{}
+
+func (a *queryArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// bboxCondition matches locations inside bbox, compared as geometry so
+// that the box's edges follow meridians and parallels rather than great
+// circles; idx_hazards_location_geometry serves it. A box crossing the
+// antimeridian is split into one envelope on each side of it.
+func bboxCondition(bbox models.BoundingBox, args *queryArgs) string {
+	envelope := func(minLon, maxLon float64) string {
+		return fmt.Sprintf("ST_Intersects(location::geometry, ST_MakeEnvelope(%s, %s, %s, %s, 4326))",
+			args.add(minLon), args.add(bbox.MinLat), args.add(maxLon), args.add(bbox.MaxLat))
+	}
+	if !bbox.CrossesAntimeridian() {
+		return envelope(bbox.MinLon, bbox.MaxLon)
+	}
+	return "(" + envelope(bbox.MinLon, 180) + " OR " + envelope(-180, bbox.MaxLon) + ")"
+}
+
+// filterConditions returns the WHERE conditions for f. Deleted and hidden
+// hazards are always excluded, as are shadowed ones not reported by the
+// viewer.
+func filterConditions(f *models.HazardFilter, args *queryArgs) []string {
+	conds := []string{visible, unshadowed(args.add(f.ViewerID))}
+	if len(f.Types) > 0 {
+		conds = append(conds, "type = ANY("+args.add(pq.Array(f.Types))+")")
+	}
+	if len(f.Severities) > 0 {
+		conds = append(conds, "severity = ANY("+args.add(pq.Array(f.Severities))+")")
+	}
+	if f.VerifiedOnly {
+		conds = append(conds, "is_verified")
+	}
+	if f.Status != nil {
+		conds = append(conds, "status = "+args.add(*f.Status))
+	}
+	if f.CreatedSince != nil {
+		conds = append(conds, "created_at >= "+args.add(*f.CreatedSince))
+	}
+	if f.ReporterID != nil {
+		conds = append(conds, "user_id = "+args.add(*f.ReporterID))
+	}
+	return conds
+}
+
 type Repository struct {
 	db *sql.DB
 }
@@ -17,37 +157,100 @@ func NewRepository(db *sql.DB) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) Create(ctx context.Context, hazard *models.Hazard) error {
-	query := `
-		INSERT INTO hazards (id, user_id, type, latitude, longitude, image_url, severity, description, reported_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING created_at, updated_at
-	`
+const insertHazard = `
+		INSERT INTO hazards (id, user_id, type, latitude, longitude, image_url, severity, description, reported_by,
+		                     bearing, lane, client_key, captured_at, shadowed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+`
 
-	return r.db.QueryRowContext(
-		ctx, query,
+func insertArgs(hazard *models.Hazard) []interface{} {
+	return []interface{}{
 		hazard.ID, hazard.UserID, hazard.Type, hazard.Latitude, hazard.Longitude,
 		hazard.ImageURL, hazard.Severity, hazard.Description, hazard.ReportedBy,
-	).Scan(&hazard.CreatedAt, &hazard.UpdatedAt)
+		hazard.Bearing, hazard.Lane, hazard.ClientKey, hazard.CapturedAt, hazard.Shadowed,
+	}
 }
 
-func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*models.Hazard, error) {
-	query := `
-		SELECT id, user_id, type, latitude, longitude, image_url, severity, description,
-		       is_verified, verify_count, reported_by, created_at, updated_at
-		FROM hazards
-		WHERE id = $1
+func (r *Repository) Create(ctx context.Context, hazard *models.Hazard) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := reserveReport(ctx, tx, hazard); err != nil {
+		return err
+	}
+
+	query := insertHazard + `RETURNING status, created_at, updated_at`
+	err = tx.QueryRowContext(ctx, query, insertArgs(hazard)...).
+		Scan(&hazard.Status, &hazard.CreatedAt, &hazard.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, hazard.ID, hazard.UserID, models.HazardActionCreated, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateIdempotent inserts a hazard carrying a client key. If the user has
+// already submitted that key, the original hazard is returned instead and
+// created is false. Resubmissions succeed even once the quota is used up.
+func (r *Repository) CreateIdempotent(ctx context.Context, hazard *models.Hazard) (*models.Hazard, bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	existingQuery := `SELECT ` + hazardColumns + ` FROM hazards WHERE user_id = $1 AND client_key = $2`
+
+	if err := reserveReport(ctx, tx, hazard); errors.Is(err, ErrQuotaExceeded) {
+		existing, err := scanHazard(tx.QueryRowContext(ctx, existingQuery, hazard.UserID, hazard.ClientKey))
+		if err == sql.ErrNoRows {
+			return nil, false, ErrQuotaExceeded
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return existing, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	query := insertHazard + `
+		ON CONFLICT (user_id, client_key) WHERE client_key IS NOT NULL DO NOTHING
+		RETURNING status, created_at, updated_at
 	`
+	err = tx.QueryRowContext(ctx, query, insertArgs(hazard)...).
+		Scan(&hazard.Status, &hazard.CreatedAt, &hazard.UpdatedAt)
+	if err == nil {
+		if err := recordEvent(ctx, tx, hazard.ID, hazard.UserID, models.HazardActionCreated, nil); err != nil {
+			return nil, false, err
+		}
+		return hazard, true, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
 
-	hazard := &models.Hazard{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&hazard.ID, &hazard.UserID, &hazard.Type, &hazard.Latitude, &hazard.Longitude,
-		&hazard.ImageURL, &hazard.Severity, &hazard.Description, &hazard.IsVerified,
-		&hazard.VerifyCount, &hazard.ReportedBy, &hazard.CreatedAt, &hazard.UpdatedAt,
-	)
+	existing, err := scanHazard(tx.QueryRowContext(ctx, existingQuery, hazard.UserID, hazard.ClientKey))
+	if err != nil {
+		return nil, false, err
+	}
 
+	return existing, false, nil
+}
+
+func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*models.Hazard, error) {
+	query := `SELECT ` + hazardColumns + ` FROM hazards WHERE id = $1 AND deleted_at IS NULL`
+
+	hazard, err := scanHazard(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("hazard not found")
+		return nil, ErrNotFound
 	}
 	if err != nil {
 		return nil, err
@@ -56,38 +259,274 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*models.Hazard,
 	return hazard, nil
 }
 
-func (r *Repository) GetNearby(ctx context.Context, lat, lon, radiusKm float64, limit int) ([]*models.Hazard, error) {
-	query := `
-		SELECT id, user_id, type, latitude, longitude, image_url, severity, description,
-		       is_verified, verify_count, reported_by, created_at, updated_at,
-		       ST_Distance(location, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography) / 1000 as distance
+// Search returns one page of hazards within q.Radius of the query point,
+// applying the optional filters and keyset pagination from q.Cursor.
+func (r *Repository) Search(ctx context.Context, q *models.HazardQuery) (*models.HazardPage, error) {
+	radius := DefaultRadiusKm
+	if q.Radius != nil {
+		radius = *q.Radius
+	}
+	limit := DefaultLimit
+	if q.Limit != nil {
+		limit = *q.Limit
+	}
+	sort := q.Sort
+	if sort == "" {
+		sort = models.HazardSortDistance
+	}
+
+	var cursor *Cursor
+	if q.Cursor != "" {
+		c, err := DecodeCursor(q.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		cursor = c
+	}
+
+	var args queryArgs
+	point := fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography", args.add(q.Longitude), args.add(q.Latitude))
+	distance := fmt.Sprintf("ST_Distance(location, %s) / 1000", point)
+
+	conds := []string{
+		fmt.Sprintf("ST_DWithin(location, %s, %s * 1000)", point, args.add(radius)),
+	}
+	conds = append(conds, filterConditions(&q.HazardFilter, &args)...)
+
+	var orderBy string
+	switch sort {
+	case models.HazardSortRecent:
+		orderBy = "created_at DESC, id DESC"
+		if cursor != nil {
+			conds = append(conds, fmt.Sprintf("(created_at, id) < (%s, %s)",
+				args.add(cursor.CreatedAt), args.add(cursor.ID)))
+		}
+	case models.HazardSortSeverity:
+		orderBy = "severity_rank DESC, created_at DESC, id DESC"
+		if cursor != nil {
+			conds = append(conds, fmt.Sprintf("(%s, created_at, id) < (%s, %s, %s)",
+				severityRank, args.add(cursor.SeverityRank), args.add(cursor.CreatedAt), args.add(cursor.ID)))
+		}
+	default:
+		orderBy = "distance ASC, id ASC"
+		if cursor != nil {
+			conds = append(conds, fmt.Sprintf("(%s, id) > (%s, %s)",
+				distance, args.add(cursor.Distance), args.add(cursor.ID)))
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s AS distance, %s AS severity_rank
+		FROM hazards
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s
+	`, hazardColumns, distance, severityRank, strings.Join(conds, " AND "), orderBy, args.add(limit+1))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.HazardPage{Hazards: []*models.Hazard{}}
+	var last Cursor
+	for rows.Next() {
+		var distance float64
+		var rank int
+
+		hazard, err := scanHazard(rows, &distance, &rank)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Hazards) == limit {
+			next := last.Encode()
+			page.NextCursor = &next
+			break
+		}
+
+		hazard.Distance = &distance
+		page.Hazards = append(page.Hazards, hazard)
+		last = Cursor{Sort: sort, Distance: distance, SeverityRank: rank, CreatedAt: hazard.CreatedAt, ID: hazard.ID}
+	}
+
+	return page, rows.Err()
+}
+
+// Viewport returns the hazards inside the bounding box, or grid clusters when
+// the box holds more than ClusterThreshold of them.
+func (r *Repository) Viewport(ctx context.Context, q *models.ViewportQuery) (*models.HazardViewport, error) {
+	var args queryArgs
+	conds := []string{bboxCondition(q.BBox, &args)}
+	conds = append(conds, filterConditions(&q.HazardFilter, &args)...)
+	where := strings.Join(conds, " AND ")
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM hazards
+		WHERE %s
+		ORDER BY %s DESC, created_at DESC
+		LIMIT %d
+	`, hazardColumns, where, severityRank, ClusterThreshold+1)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	viewport := &models.HazardViewport{Hazards: []*models.Hazard{}}
+	for rows.Next() {
+		hazard, err := scanHazard(rows)
+		if err != nil {
+			return nil, err
+		}
+		viewport.Hazards = append(viewport.Hazards, hazard)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(viewport.Hazards) <= ClusterThreshold {
+		return viewport, nil
+	}
+
+	clusters, err := r.clusters(ctx, q.BBox, where, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.HazardViewport{Clusters: clusters}, nil
+}
+
+func (r *Repository) clusters(ctx context.Context, bbox models.BoundingBox, where string, args queryArgs) ([]*models.HazardCluster, error) {
+	cellSize := math.Max(bbox.Width(), bbox.MaxLat-bbox.MinLat) / ClusterGridSize
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(*),
+		       ST_Y(ST_Centroid(ST_Collect(location::geometry))),
+		       ST_X(ST_Centroid(ST_Collect(location::geometry))),
+		       CASE MAX(%s) WHEN 3 THEN 'high' WHEN 2 THEN 'medium' ELSE 'low' END
+		FROM hazards
+		WHERE %s
+		GROUP BY ST_SnapToGrid(location::geometry, %s)
+	`, severityRank, where, args.add(cellSize))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clusters := []*models.HazardCluster{}
+	for rows.Next() {
+		cluster := &models.HazardCluster{}
+		if err := rows.Scan(&cluster.Count, &cluster.Latitude, &cluster.Longitude, &cluster.MaxSeverity); err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, rows.Err()
+}
+
+// AlongRoute returns hazards within corridorM metres of the route, ordered by
+// how far along the route they lie. Each hazard's RouteOffset is the distance
+// in metres from the start of the route to its closest point on the line,
+// measured on the spheroid along the part of the route before it.
+func (r *Repository) AlongRoute(ctx context.Context, route []geo.Point, corridorM float64, filter *models.HazardFilter, limit int) ([]*models.Hazard, error) {
+	var args queryArgs
+	line := args.add(geo.LineStringEWKT(route))
+	conds := []string{
+		fmt.Sprintf("ST_DWithin(location, route.line, %s)", args.add(corridorM)),
+	}
+	conds = append(conds, filterConditions(filter, &args)...)
+
+	query := fmt.Sprintf(`
+		WITH route AS (SELECT ST_GeogFromText(%s) AS line)
+		SELECT %s,
+		       ST_Distance(location, route.line) / 1000 AS distance,
+		       ST_Length(ST_LineSubstring(route.line::geometry, 0,
+		           ST_LineLocatePoint(route.line::geometry, location::geometry))::geography) AS route_offset
+		FROM hazards, route
+		WHERE %s
+		ORDER BY route_offset ASC, id ASC
+		LIMIT %s
+	`, line, hazardColumns, strings.Join(conds, " AND "), args.add(limit))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hazards := []*models.Hazard{}
+	for rows.Next() {
+		var distance, offset float64
+
+		hazard, err := scanHazard(rows, &distance, &offset)
+		if err != nil {
+			return nil, err
+		}
+
+		hazard.Distance = &distance
+		hazard.RouteOffset = &offset
+		hazards = append(hazards, hazard)
+	}
+
+	return hazards, rows.Err()
+}
+
+// LookAheadDistance returns how far ahead, in metres, drive mode searches for
+// a vehicle travelling at speedKmh.
+func LookAheadDistance(speedKmh float64) float64 {
+	d := speedKmh / 3.6 * LookAheadSeconds
+	return math.Min(math.Max(d, MinLookAheadM), MaxLookAheadM)
+}
+
+// Ahead returns active hazards in front of a moving vehicle: within the
+// speed-dependent look-ahead distance, inside the tolerance cone around the
+// heading, and, when the reporter recorded a bearing, on the same
+// carriageway direction.
+func (r *Repository) Ahead(ctx context.Context, q *models.DriveQuery) ([]*models.Hazard, error) {
+	tolerance := DefaultToleranceDeg
+	if q.ToleranceDeg != nil {
+		tolerance = *q.ToleranceDeg
+	}
+
+	var args queryArgs
+	point := fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography", args.add(q.Longitude), args.add(q.Latitude))
+	heading := args.add(q.Heading)
+	azimuth := fmt.Sprintf("COALESCE(degrees(ST_Azimuth(%s, location)), %s)", point, heading)
+
+	query := fmt.Sprintf(`
+		SELECT %s, ST_Distance(location, %s) / 1000 AS distance
 		FROM hazards
-		WHERE ST_DWithin(
-			location,
-			ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography,
-			$3 * 1000
-		)
+		WHERE ST_DWithin(location, %s, %s)
+		  AND status = 'active'
+		  AND `+visible+`
+		  AND %s
+		  AND %s <= %s
+		  AND (bearing IS NULL OR %s <= %s)
 		ORDER BY distance ASC
-		LIMIT $4
-	`
+		LIMIT %d
+	`, hazardColumns, point, point, args.add(LookAheadDistance(q.SpeedKmh)), unshadowed(args.add(q.ViewerID)),
+		fmt.Sprintf(angleDiff, azimuth, heading), args.add(tolerance),
+		fmt.Sprintf(angleDiff, "bearing", heading), args.add(DirectionToleranceDeg),
+		DriveLimit)
 
-	rows, err := r.db.QueryContext(ctx, query, lon, lat, radiusKm, limit)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var hazards []*models.Hazard
+	hazards := []*models.Hazard{}
 	for rows.Next() {
-		hazard := &models.Hazard{}
 		var distance float64
 
-		err := rows.Scan(
-			&hazard.ID, &hazard.UserID, &hazard.Type, &hazard.Latitude, &hazard.Longitude,
-			&hazard.ImageURL, &hazard.Severity, &hazard.Description, &hazard.IsVerified,
-			&hazard.VerifyCount, &hazard.ReportedBy, &hazard.CreatedAt, &hazard.UpdatedAt,
-			&distance,
-		)
+		hazard, err := scanHazard(rows, &distance)
 		if err != nil {
 			return nil, err
 		}
@@ -99,9 +538,209 @@ func (r *Repository) GetNearby(ctx context.Context, lat, lon, radiusKm float64,
 	return hazards, rows.Err()
 }
 
-func (r *Repository) Delete(ctx context.Context, id uuid.UUID) error {
-	query := `DELETE FROM hazards WHERE id = $1`
-	result, err := r.db.ExecContext(ctx, query, id)
+// Changes returns what changed inside bbox after cursor, collapsed to the
+// latest state per hazard. A nil cursor starts from the beginning of the log.
+// Hazards shadowed from viewerID come back as tombstones.
+func (r *Repository) Changes(ctx context.Context, cursor *ChangeCursor, bbox models.BoundingBox, limit int, viewerID uuid.UUID) (*models.HazardChanges, error) {
+	if cursor == nil {
+		cursor = &ChangeCursor{}
+	}
+
+	args := queryArgs{cursor.TxID, cursor.Seq}
+	query := fmt.Sprintf(`
+		SELECT txid, seq, hazard_id, op
+		FROM hazard_changes
+		WHERE (txid, seq) > ($1, $2)
+		  AND txid < txid_snapshot_xmin(txid_current_snapshot())
+		  AND %s
+		ORDER BY txid, seq
+		LIMIT %s
+	`, bboxCondition(bbox, &args), args.add(limit+1))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := &models.HazardChanges{
+		Upserts:    []*models.Hazard{},
+		Tombstones: []*models.HazardTombstone{},
+	}
+	next := *cursor
+	var order []uuid.UUID
+	latest := make(map[uuid.UUID]string)
+
+	for n := 0; rows.Next(); n++ {
+		if n == limit {
+			changes.HasMore = true
+			break
+		}
+
+		var c ChangeCursor
+		var hazardID uuid.UUID
+		var op string
+		if err := rows.Scan(&c.TxID, &c.Seq, &hazardID, &op); err != nil {
+			return nil, err
+		}
+
+		if _, seen := latest[hazardID]; !seen {
+			order = append(order, hazardID)
+		}
+		latest[hazardID] = op
+		next = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	changes.NextCursor = next.Encode()
+
+	var upsertIDs []uuid.UUID
+	for _, id := range order {
+		if latest[id] == "upsert" {
+			upsertIDs = append(upsertIDs, id)
+		}
+	}
+
+	current, err := r.getMany(ctx, upsertIDs, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range order {
+		hazard, ok := current[id]
+		switch {
+		case !ok:
+			changes.Tombstones = append(changes.Tombstones, &models.HazardTombstone{ID: id, Reason: models.TombstoneDeleted})
+		case hazard.Status == models.HazardStatusResolved:
+			changes.Tombstones = append(changes.Tombstones, &models.HazardTombstone{ID: id, Reason: models.TombstoneResolved})
+		default:
+			changes.Upserts = append(changes.Upserts, hazard)
+		}
+	}
+
+	return changes, nil
+}
+
+func (r *Repository) getMany(ctx context.Context, ids []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID]*models.Hazard, error) {
+	found := make(map[uuid.UUID]*models.Hazard, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	query := `SELECT ` + hazardColumns + ` FROM hazards WHERE id = ANY($1) AND ` + visible + ` AND ` + unshadowed("$2")
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hazard, err := scanHazard(rows)
+		if err != nil {
+			return nil, err
+		}
+		found[hazard.ID] = hazard
+	}
+
+	return found, rows.Err()
+}
+
+// Update applies a partial edit if the hazard is still at version
+// unmodifiedSince, recording the changed fields in its history. It returns
+// the updated hazard and the changes made, keyed by field name.
+func (r *Repository) Update(ctx context.Context, id, actorID uuid.UUID, unmodifiedSince time.Time, u *models.HazardUpdate) (*models.Hazard, map[string]interface{}, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + hazardColumns + ` FROM hazards WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	current, err := scanHazard(tx.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !current.UpdatedAt.Equal(unmodifiedSince) {
+		return nil, nil, ErrModified
+	}
+
+	var args queryArgs
+	var sets []string
+	changes := make(map[string]interface{})
+	set := func(column string, from, to interface{}) {
+		sets = append(sets, column+" = "+args.add(to))
+		changes[column] = map[string]interface{}{"from": from, "to": to}
+	}
+
+	if u.Type != nil && *u.Type != current.Type {
+		set("type", current.Type, *u.Type)
+	}
+	if u.Severity != nil && *u.Severity != current.Severity {
+		set("severity", current.Severity, *u.Severity)
+	}
+	if u.Description != nil && (current.Description == nil || *u.Description != *current.Description) {
+		set("description", current.Description, *u.Description)
+	}
+	if u.Bearing != nil && (current.Bearing == nil || *u.Bearing != *current.Bearing) {
+		set("bearing", current.Bearing, *u.Bearing)
+	}
+	if u.Lane != nil && (current.Lane == nil || *u.Lane != *current.Lane) {
+		set("lane", current.Lane, *u.Lane)
+	}
+	if u.Status != nil && *u.Status != current.Status {
+		set("status", current.Status, *u.Status)
+	}
+
+	if len(sets) == 0 {
+		return current, changes, nil
+	}
+
+	query = fmt.Sprintf(`UPDATE hazards SET %s WHERE id = %s RETURNING %s`,
+		strings.Join(sets, ", "), args.add(id), hazardColumns)
+	updated, err := scanHazard(tx.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if status, ok := changes["status"]; ok {
+		if err := recordEvent(ctx, tx, id, actorID, models.HazardActionStatusChanged, status.(map[string]interface{})); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := recordEvent(ctx, tx, id, actorID, models.HazardActionUpdated, changes); err != nil {
+		return nil, nil, err
+	}
+
+	return updated, changes, tx.Commit()
+}
+
+// Delete soft-deletes a hazard, keeping the row, its verifications and
+// notifications, and records who deleted it and why.
+func (r *Repository) Delete(ctx context.Context, id, actorID uuid.UUID, reason *string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := softDelete(ctx, tx, id, actorID, reason); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func softDelete(ctx context.Context, ex execer, id, actorID uuid.UUID, reason *string) error {
+	query := `
+		UPDATE hazards
+		SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2, delete_reason = $3
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	result, err := ex.ExecContext(ctx, query, id, actorID, reason)
 	if err != nil {
 		return err
 	}
@@ -112,21 +751,52 @@ func (r *Repository) Delete(ctx context.Context, id uuid.UUID) error {
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("hazard not found")
+		return ErrNotFound
 	}
 
-	return nil
+	var data map[string]interface{}
+	if reason != nil {
+		data = map[string]interface{}{"reason": *reason}
+	}
+	return recordEvent(ctx, ex, id, actorID, models.HazardActionDeleted, data)
 }
 
 func (r *Repository) VerifyHazard(ctx context.Context, hazardID, userID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM hazards WHERE id = $1 AND `+visible+`)`, hazardID).
+		Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	// Verifications by shadow-banned users are recorded but not counted
 	query := `
-		INSERT INTO hazard_verifications (hazard_id, user_id)
-		VALUES ($1, $2)
+		INSERT INTO hazard_verifications (hazard_id, user_id, counted)
+		SELECT $1, $2, status <> 'shadow_banned' FROM users WHERE id = $2
 		ON CONFLICT (hazard_id, user_id) DO NOTHING
 	`
 
-	_, err := r.db.ExecContext(ctx, query, hazardID, userID)
-	return err
+	result, err := tx.ExecContext(ctx, query, hazardID, userID)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+		if err := recordEvent(ctx, tx, hazardID, userID, models.HazardActionVerified, nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (r *Repository) GetUsersNearby(ctx context.Context, lat, lon, radiusKm float64) ([]uuid.UUID, error) {
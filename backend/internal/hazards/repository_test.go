@@ -0,0 +1,132 @@
+package hazards
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+
+	_ "github.com/lib/pq"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// testDB connects to TEST_DATABASE_URL, a throwaway database with every
+// migration applied. Tests that need it are skipped when it is not set.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBBoxCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		bbox     models.BoundingBox
+		want     string
+		wantArgs queryArgs
+	}{
+		{
+			name:     "ordinary box",
+			bbox:     models.BoundingBox{MinLon: -122.5, MinLat: 37.7, MaxLon: -122.3, MaxLat: 37.8},
+			want:     "ST_Intersects(location::geometry, ST_MakeEnvelope($1, $2, $3, $4, 4326))",
+			wantArgs: queryArgs{-122.5, 37.7, -122.3, 37.8},
+		},
+		{
+			name: "across the antimeridian",
+			bbox: models.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0},
+			want: "(ST_Intersects(location::geometry, ST_MakeEnvelope($1, $2, $3, $4, 4326))" +
+				" OR ST_Intersects(location::geometry, ST_MakeEnvelope($5, $6, $7, $8, 4326)))",
+			wantArgs: queryArgs{170.0, -20.0, 180.0, 0.0, -180.0, -20.0, -170.0, 0.0},
+		},
+		{
+			name:     "whole world",
+			bbox:     models.BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90},
+			want:     "ST_Intersects(location::geometry, ST_MakeEnvelope($1, $2, $3, $4, 4326))",
+			wantArgs: queryArgs{-180.0, -90.0, 180.0, 90.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args queryArgs
+			if got := bboxCondition(tt.bbox, &args); got != tt.want {
+				t.Errorf("bboxCondition() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBBoxConditionMatches(t *testing.T) {
+	db := testDB(t)
+	world := models.BoundingBox{MinLon: -180, MinLat: -90, MaxLon: 180, MaxLat: 90}
+	// A wide band in mid latitudes, whose top edge as a great circle would
+	// bow far north of the 50th parallel
+	band := models.BoundingBox{MinLon: -170, MinLat: 40, MaxLon: 170, MaxLat: 50}
+
+	tests := []struct {
+		name     string
+		bbox     models.BoundingBox
+		lon, lat float64
+		want     bool
+	}{
+		{"world, near the antimeridian", world, 179.9, 0, true},
+		{"world, near a pole", world, 0, 89.9, true},
+		{"world, origin", world, 0, 0, true},
+		{"band, inside at its top edge", band, 0, 49.9, true},
+		{"band, above its top edge", band, 0, 50.5, false},
+		{"band, outside in longitude", band, 175, 45, false},
+		{"across the antimeridian, east", models.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0}, 175, -10, true},
+		{"across the antimeridian, west", models.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0}, -175, -10, true},
+		{"across the antimeridian, outside", models.BoundingBox{MinLon: 170, MinLat: -20, MaxLon: -170, MaxLat: 0}, 0, -10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args queryArgs
+			cond := bboxCondition(tt.bbox, &args)
+			query := "SELECT " + cond + " FROM (SELECT ST_SetSRID(ST_MakePoint(" +
+				args.add(tt.lon) + ", " + args.add(tt.lat) + "), 4326)::geography AS location) h"
+
+			var got bool
+			if err := db.QueryRow(query, args...).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("(%v, %v) matches = %v, want %v", tt.lon, tt.lat, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoundingBoxWidth(t *testing.T) {
+	tests := []struct {
+		bbox    models.BoundingBox
+		crosses bool
+		width   float64
+	}{
+		{models.BoundingBox{MinLon: -10, MaxLon: 10}, false, 20},
+		{models.BoundingBox{MinLon: 170, MaxLon: -170}, true, 20},
+		{models.BoundingBox{MinLon: 179, MaxLon: -179.5}, true, 1.5},
+		{models.BoundingBox{MinLon: -180, MaxLon: 180}, false, 360},
+	}
+	for _, tt := range tests {
+		if got := tt.bbox.CrossesAntimeridian(); got != tt.crosses {
+			t.Errorf("%+v CrossesAntimeridian() = %v, want %v", tt.bbox, got, tt.crosses)
+		}
+		if got := tt.bbox.Width(); got != tt.width {
+			t.Errorf("%+v Width() = %v, want %v", tt.bbox, got, tt.width)
+		}
+	}
+}
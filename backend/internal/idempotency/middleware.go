@@ -0,0 +1,254 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/roadeye/backend/internal/auth"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKeyLength  = 255
+	maxBodyBytes  = 1 << 20
+	inFlightTTL   = time.Minute
+	keyPrefix     = "idempotency:"
+	stateInFlight = "in_flight"
+	stateDone     = "done"
+)
+
+// Errors returned by Once, for the cases the middleware answers itself.
+var (
+	ErrKeyTooLong  = errors.New("idempotency key too long")
+	ErrInProgress  = errors.New("request with idempotency key in progress")
+	ErrKeyReused   = errors.New("idempotency key used with a different request")
+	ErrUnavailable = errors.New("idempotency store unavailable")
+)
+
+type record struct {
+	State       string `json:"state"`
+	Fingerprint string `json:"fingerprint"`
+	ResourceID  string `json:"resource_id,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Store remembers responses to mutating requests that carry an
+// Idempotency-Key header so that client retries replay the original response.
+type Store struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewStore(client *redis.Client, ttl time.Duration) *Store {
+	return &Store{client: client, ttl: ttl}
+}
+
+// Middleware must run after authentication, as keys are scoped per user.
+// Anonymous requests are passed through untouched, so responses carrying
+// credentials are never stored.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		userID, ok := auth.GetUserIDFromContext(r.Context())
+		if key == "" || !ok || !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		if err != nil || len(body) > maxBodyBytes {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		redisKey := keyPrefix + userID.String() + ":" + key
+		fingerprint := Fingerprint(r, body)
+		ctx := r.Context()
+
+		claimed, err := s.claim(ctx, redisKey, fingerprint)
+		if err != nil {
+			http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !claimed {
+			existing, err := s.get(ctx, redisKey)
+			if err != nil {
+				http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			switch {
+			case existing == nil:
+				http.Error(w, "Request with this Idempotency-Key is in progress", http.StatusConflict)
+			case existing.Fingerprint != fingerprint:
+				http.Error(w, "Idempotency-Key was used with a different request", http.StatusUnprocessableEntity)
+			case existing.State != stateDone:
+				http.Error(w, "Request with this Idempotency-Key is in progress", http.StatusConflict)
+			default:
+				replay(w, existing)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not remembered so the client can retry them.
+		if rec.status >= http.StatusInternalServerError {
+			s.client.Del(context.Background(), redisKey)
+			return
+		}
+
+		s.save(context.Background(), redisKey, &record{
+			State:       stateDone,
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+	})
+}
+
+// Once runs create at most once for a key within scope, for handlers whose
+// responses must not be stored because they carry credentials. Only the ID
+// that create returns is kept: a retry with the same fingerprint gets it
+// back with replayed set, and the handler builds a fresh response for it.
+// The key is released when create fails, so the request can be retried.
+func (s *Store) Once(ctx context.Context, scope, key, fingerprint string, create func() (string, error)) (id string, replayed bool, err error) {
+	if len(key) > maxKeyLength {
+		return "", false, ErrKeyTooLong
+	}
+	redisKey := keyPrefix + scope + ":" + key
+
+	claimed, err := s.claim(ctx, redisKey, fingerprint)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if !claimed {
+		existing, err := s.get(ctx, redisKey)
+		switch {
+		case err != nil:
+			return "", false, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		case existing == nil:
+			return "", false, ErrInProgress
+		case existing.Fingerprint != fingerprint:
+			return "", false, ErrKeyReused
+		case existing.State != stateDone:
+			return "", false, ErrInProgress
+		}
+		return existing.ResourceID, true, nil
+	}
+
+	id, err = create()
+	if err != nil {
+		s.client.Del(context.Background(), redisKey)
+		return "", false, err
+	}
+	s.save(context.Background(), redisKey, &record{
+		State:       stateDone,
+		Fingerprint: fingerprint,
+		ResourceID:  id,
+	})
+	return id, false, nil
+}
+
+func (s *Store) claim(ctx context.Context, key, fingerprint string) (bool, error) {
+	data, err := json.Marshal(&record{State: stateInFlight, Fingerprint: fingerprint})
+	if err != nil {
+		return false, err
+	}
+	return s.client.SetNX(ctx, key, data, inFlightTTL).Result()
+}
+
+func (s *Store) get(ctx context.Context, key string) (*record, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (s *Store) save(ctx context.Context, key string, rec *record) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	s.client.Set(ctx, key, data, s.ttl)
+}
+
+func replay(w http.ResponseWriter, rec *record) {
+	if rec.ContentType != "" {
+		w.Header().Set("Content-Type", rec.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Fingerprint identifies a request by its method, URI and body, so that a
+// key reused for a different request can be told apart from a retry.
+func Fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, " ")
+	io.WriteString(h, r.URL.RequestURI())
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy for replay.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
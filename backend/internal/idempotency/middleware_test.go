@@ -0,0 +1,67 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStoreOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	s := NewStore(client, time.Hour)
+	ctx := context.Background()
+
+	created := 0
+	create := func() (string, error) {
+		created++
+		return "user-1", nil
+	}
+	errTaken := errors.New("taken")
+	failing := func() (string, error) {
+		return "", errTaken
+	}
+
+	tests := []struct {
+		name         string
+		scope, key   string
+		fingerprint  string
+		create       func() (string, error)
+		wantID       string
+		wantReplayed bool
+		wantErr      error
+		wantCreated  int
+	}{
+		{"first request", "register:203.0.113.7", "k1", "a", create, "user-1", false, nil, 1},
+		{"retry", "register:203.0.113.7", "k1", "a", create, "user-1", true, nil, 1},
+		{"different request", "register:203.0.113.7", "k1", "b", create, "", false, ErrKeyReused, 1},
+		{"other address", "register:198.51.100.1", "k1", "a", create, "user-1", false, nil, 2},
+		{"failure", "register:203.0.113.7", "k2", "a", failing, "", false, errTaken, 2},
+		{"retry after failure", "register:203.0.113.7", "k2", "a", create, "user-1", false, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, replayed, err := s.Once(ctx, tt.scope, tt.key, tt.fingerprint, tt.create)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Once() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantID || replayed != tt.wantReplayed {
+				t.Errorf("Once() = %q, %v, want %q, %v", id, replayed, tt.wantID, tt.wantReplayed)
+			}
+			if created != tt.wantCreated {
+				t.Errorf("created %d times, want %d", created, tt.wantCreated)
+			}
+		})
+	}
+
+	// A request still running holds the key
+	mr.Set(keyPrefix+"register:203.0.113.7:k3", `{"state":"in_flight","fingerprint":"a"}`)
+	if _, _, err := s.Once(ctx, "register:203.0.113.7", "k3", "a", create); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Once() while in flight error = %v, want %v", err, ErrInProgress)
+	}
+}
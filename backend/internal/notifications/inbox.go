@@ -0,0 +1,140 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/pagination"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	DefaultInboxLimit = 20
+	MaxInboxLimit     = 100
+)
+
+var (
+	ErrNotFound      = errors.New("notification not found")
+	ErrInvalidCursor = pagination.ErrInvalidCursor
+)
+
+// Inbox returns a page of the user's notifications, newest first, each with
+// its hazard's current state, and the number of unread notifications.
+func (r *Repository) Inbox(ctx context.Context, userID uuid.UUID, after string, limit int, unreadOnly bool) (*models.NotificationPage, error) {
+	var c *pagination.Cursor
+	if after != "" {
+		var err error
+		if c, err = pagination.DecodeCursor(after); err != nil {
+			return nil, err
+		}
+	}
+	var afterTime *time.Time
+	var afterID *uuid.UUID
+	if c != nil {
+		afterTime, afterID = &c.CreatedAt, &c.ID
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT n.id, n.user_id, n.hazard_id, n.title, n.body, COALESCE(n.data::text, ''), n.sent,
+		       n.read_at, n.created_at,
+		       h.deleted_at IS NOT NULL OR h.hidden_at IS NOT NULL,
+		       h.type, h.severity, h.status, h.latitude, h.longitude, h.updated_at
+		FROM notifications n JOIN hazards h ON h.id = n.hazard_id
+		WHERE n.user_id = $1
+		  AND ($2::timestamptz IS NULL OR (n.created_at, n.id) < ($2, $3))
+		  AND (NOT $4 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $5
+	`, userID, afterTime, afterID, unreadOnly, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &models.NotificationPage{Notifications: []*models.Notification{}}
+	for rows.Next() {
+		n := &models.Notification{}
+		hazard := &models.NotificationHazard{}
+		err := rows.Scan(
+			&n.ID, &n.UserID, &n.HazardID, &n.Title, &n.Body, &n.Data, &n.Sent, &n.ReadAt, &n.CreatedAt,
+			&hazard.Removed, &hazard.Type, &hazard.Severity, &hazard.Status,
+			&hazard.Latitude, &hazard.Longitude, &hazard.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Notifications) == limit {
+			last := page.Notifications[limit-1]
+			next := pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+			page.NextCursor = &next
+			break
+		}
+
+		hazard.ID = n.HazardID
+		if hazard.Removed {
+			hazard = &models.NotificationHazard{ID: n.HazardID, Removed: true}
+		}
+		n.Hazard = hazard
+		page.Notifications = append(page.Notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL
+	`, userID).Scan(&page.UnreadCount)
+	return page, err
+}
+
+// MarkRead marks a notification of userID as read. Marking it again keeps
+// the original time.
+func (r *Repository) MarkRead(ctx context.Context, userID, id uuid.UUID) (*time.Time, error) {
+	var readAt time.Time
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND user_id = $2
+		RETURNING read_at
+	`, id, userID).Scan(&readAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &readAt, nil
+}
+
+// MarkAllRead marks every unread notification of userID created up to
+// before as read, so that alerts arriving meanwhile stay unread. It returns
+// how many were marked.
+func (r *Repository) MarkAllRead(ctx context.Context, userID uuid.UUID, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL AND created_at <= $2
+	`, userID, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Delete removes a notification of userID.
+func (r *Repository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM notifications WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
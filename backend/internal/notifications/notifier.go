@@ -0,0 +1,382 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/geo"
+	"github.com/roadeye/backend/internal/mail"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	// digestInterval is how often held-back alerts are checked for digests
+	// that are due.
+	digestInterval = 5 * time.Second
+	// commentExcerptLen is how much of a comment its alert quotes, in runes.
+	commentExcerptLen = 140
+)
+
+// Notifier alerts users about new hazards according to their settings,
+// within the limits kept by the throttle.
+type Notifier struct {
+	repo     *Repository
+	pusher   Pusher
+	mailer   mail.Mailer
+	throttle *Throttle
+}
+
+func NewNotifier(repo *Repository, pusher Pusher, mailer mail.Mailer, throttle *Throttle) *Notifier {
+	return &Notifier{repo: repo, pusher: pusher, mailer: mailer, throttle: throttle}
+}
+
+// Notify alerts matching users about hazard, nearest first, up to the
+// per-hazard cap. Every alert is recorded in the user's inbox; it is sent
+// unless it is held for a digest or the user has reached the hourly cap.
+// Alerts during the user's quiet hours are held for a digest sent when
+// they end.
+func (n *Notifier) Notify(ctx context.Context, hazard *models.Hazard) error {
+	recipients, err := n.repo.Recipients(ctx, hazard, n.throttle.config.MaxPerHazard)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rcpt := range recipients {
+		// Another replica, or an earlier event, may have got there first
+		claimed, err := n.throttle.Claim(ctx, rcpt.UserID, hazard.ID)
+		if err != nil {
+			log.Printf("Failed to claim notification for user %s: %v", rcpt.UserID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		within, err := n.throttle.CountForHazard(ctx, hazard.ID)
+		if err != nil {
+			log.Printf("Failed to count notifications for hazard %s: %v", hazard.ID, err)
+			continue
+		}
+		if !within {
+			log.Printf("Notification cap reached for hazard %s", hazard.ID)
+			break
+		}
+
+		payload := hazardAlert(hazard, rcpt)
+		id, err := n.repo.Record(ctx, rcpt.UserID, hazard.ID, payload)
+		if err != nil {
+			log.Printf("Failed to record notification for user %s: %v", rcpt.UserID, err)
+			continue
+		}
+
+		item := &DigestItem{NotificationID: id, HazardID: hazard.ID}
+		if end, ok := quietUntil(rcpt.Settings, now); ok {
+			if err := n.throttle.Defer(ctx, rcpt.UserID, []*DigestItem{item}, end); err != nil {
+				log.Printf("Failed to queue digest for user %s: %v", rcpt.UserID, err)
+			}
+			continue
+		}
+		held, err := n.throttle.Hold(ctx, rcpt.UserID, item)
+		if err != nil {
+			log.Printf("Failed to queue digest for user %s: %v", rcpt.UserID, err)
+		}
+		if held {
+			continue
+		}
+
+		n.deliver(ctx, rcpt, payload, []uuid.UUID{id})
+	}
+	return nil
+}
+
+// NotifyComment tells the reporter and earlier commenters of hazard about
+// comment. Every alert is recorded in the user's inbox; only the first
+// comment of a burst is sent, outside quiet hours and within the hourly
+// cap.
+func (n *Notifier) NotifyComment(ctx context.Context, hazard *models.Hazard, comment *models.Comment) error {
+	participants, err := n.repo.Participants(ctx, hazard.ID, comment.UserID, n.throttle.config.MaxPerHazard)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rcpt := range participants {
+		// Every replica receives the comment; one of them records it
+		claimed, err := n.throttle.Claim(ctx, rcpt.UserID, comment.ID)
+		if err != nil {
+			log.Printf("Failed to claim notification for user %s: %v", rcpt.UserID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		payload := commentAlert(hazard, comment, rcpt)
+		id, err := n.repo.Record(ctx, rcpt.UserID, hazard.ID, payload)
+		if err != nil {
+			log.Printf("Failed to record notification for user %s: %v", rcpt.UserID, err)
+			continue
+		}
+
+		if _, quiet := quietUntil(rcpt.Settings, now); quiet {
+			continue
+		}
+		burst, err := n.throttle.ClaimComments(ctx, rcpt.UserID, hazard.ID)
+		if err != nil {
+			log.Printf("Failed to claim comment alert for user %s: %v", rcpt.UserID, err)
+			continue
+		}
+		if !burst {
+			continue
+		}
+
+		n.deliver(ctx, rcpt, payload, []uuid.UUID{id})
+	}
+	return nil
+}
+
+// RunDigests sends due digests until ctx is done.
+func (n *Notifier) RunDigests(ctx context.Context) {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		users, err := n.throttle.DueDigests(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to get due digests: %v", err)
+			continue
+		}
+		for _, userID := range users {
+			if err := n.sendDigest(ctx, userID); err != nil {
+				log.Printf("Failed to send digest to user %s: %v", userID, err)
+			}
+		}
+	}
+}
+
+// sendDigest sends the alerts held for userID as one. Hazards removed in
+// the meantime are left out, and a digest of one is sent as a plain alert.
+// A digest that comes due in the user's quiet hours is put off until they
+// end.
+func (n *Notifier) sendDigest(ctx context.Context, userID uuid.UUID) error {
+	items, err := n.throttle.TakeDigest(ctx, userID)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	rcpt, err := n.repo.Recipient(ctx, userID)
+	if err != nil || rcpt == nil {
+		return err
+	}
+	if end, ok := quietUntil(rcpt.Settings, time.Now()); ok {
+		return n.throttle.Defer(ctx, userID, items, end)
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	hazardIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.NotificationID
+		hazardIDs[i] = item.HazardID
+	}
+	hazards, err := n.repo.DigestHazards(ctx, hazardIDs)
+	if err != nil || len(hazards) == 0 {
+		return err
+	}
+
+	payload := digestAlert(hazards, rcpt)
+	if len(hazards) == 1 {
+		payload = hazardAlert(hazards[0], rcpt)
+	}
+	n.deliver(ctx, rcpt, payload, ids)
+	return nil
+}
+
+// deliver sends payload if the recipient is within the hourly cap, and
+// marks the notifications behind it as sent if it went out.
+func (n *Notifier) deliver(ctx context.Context, rcpt *Recipient, payload *models.NotificationPayload, ids []uuid.UUID) {
+	allowed, err := n.throttle.AllowSend(ctx, rcpt.UserID)
+	if err != nil {
+		log.Printf("Failed to check notification cap for user %s: %v", rcpt.UserID, err)
+		return
+	}
+	if !allowed || !n.send(ctx, rcpt, payload) {
+		return
+	}
+	if err := n.repo.MarkSent(ctx, ids); err != nil {
+		log.Printf("Failed to mark notifications sent for user %s: %v", rcpt.UserID, err)
+	}
+}
+
+// send delivers payload on each of the recipient's channels and reports
+// whether any of them succeeded.
+func (n *Notifier) send(ctx context.Context, rcpt *Recipient, payload *models.NotificationPayload) bool {
+	sent := false
+
+	if rcpt.Settings.Channels.Push {
+		tokens, err := n.repo.DeviceTokens(ctx, rcpt.UserID)
+		if err != nil {
+			log.Printf("Failed to get device tokens for user %s: %v", rcpt.UserID, err)
+		} else if len(tokens) > 0 {
+			if err := n.pusher.Push(ctx, tokens, payload); err != nil {
+				log.Printf("Failed to push to user %s: %v", rcpt.UserID, err)
+			} else {
+				sent = true
+			}
+		}
+	}
+
+	if rcpt.Settings.Channels.Email {
+		err := n.mailer.Send(ctx, &mail.Message{To: rcpt.Email, Subject: payload.Title, Body: payload.Body})
+		if err != nil {
+			log.Printf("Failed to email user %s: %v", rcpt.UserID, err)
+		} else {
+			sent = true
+		}
+	}
+
+	return sent
+}
+
+// quietUntil reports whether now falls in the quiet hours of settings, and
+// if so when they end. An unknown time zone is treated as UTC.
+func quietUntil(settings *models.NotificationSettings, now time.Time) (time.Time, bool) {
+	if settings.QuietHours == nil {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(settings.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if !settings.QuietHours.Contains(now, loc) {
+		return time.Time{}, false
+	}
+	return settings.QuietHours.EndAfter(now, loc), true
+}
+
+// distanceKm is how far hazard is from the recipient's location, which
+// every recipient has.
+func distanceKm(settings *models.NotificationSettings, hazard *models.Hazard) float64 {
+	return geo.DistanceKm(
+		geo.Point{Lat: *settings.Latitude, Lon: *settings.Longitude},
+		geo.Point{Lat: hazard.Latitude, Lon: hazard.Longitude},
+	)
+}
+
+func alertPriority(severity models.HazardSeverity) string {
+	if severity == models.HazardSeverityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+// hazardAlert writes an alert about hazard in the recipient's language and
+// units.
+func hazardAlert(hazard *models.Hazard, rcpt *Recipient) *models.NotificationPayload {
+	r := newRenderer(rcpt.Locale, rcpt.Units)
+	msg := r.message(string(events.HazardCreated), hazard.Type)
+	vars := map[string]string{
+		"severity": r.severity(hazard.Severity),
+		"distance": r.distance(distanceKm(rcpt.Settings, hazard)),
+	}
+
+	return &models.NotificationPayload{
+		Title: r.text(msg.Title, 1, vars),
+		Body:  r.text(msg.Body, 1, vars),
+		Data: map[string]interface{}{
+			"hazard_id": hazard.ID.String(),
+			"type":      hazard.Type,
+			"severity":  hazard.Severity,
+			"latitude":  hazard.Latitude,
+			"longitude": hazard.Longitude,
+		},
+		Priority: alertPriority(hazard.Severity),
+	}
+}
+
+// commentAlert writes an alert quoting comment in the recipient's language.
+func commentAlert(hazard *models.Hazard, comment *models.Comment, rcpt *Recipient) *models.NotificationPayload {
+	r := newRenderer(rcpt.Locale, rcpt.Units)
+	msg := r.message(string(events.HazardCommented), hazard.Type)
+	excerpt := comment.Body
+	if utf8.RuneCountInString(excerpt) > commentExcerptLen {
+		excerpt = string([]rune(excerpt)[:commentExcerptLen-1]) + "…"
+	}
+	vars := map[string]string{
+		"username": comment.Username,
+		"comment":  excerpt,
+	}
+
+	return &models.NotificationPayload{
+		Title: r.text(msg.Title, 1, vars),
+		Body:  r.text(msg.Body, 1, vars),
+		Data: map[string]interface{}{
+			"hazard_id":  hazard.ID.String(),
+			"comment_id": comment.ID.String(),
+		},
+		Priority: "normal",
+	}
+}
+
+// digestAlert writes one alert about several hazards, counted by type, in
+// the recipient's language and units. Hazards all of one type may have a
+// template of their own.
+func digestAlert(hazards []*models.Hazard, rcpt *Recipient) *models.NotificationPayload {
+	r := newRenderer(rcpt.Locale, rcpt.Units)
+
+	var types []models.HazardType
+	counts := make(map[models.HazardType]int)
+	ids := make([]string, len(hazards))
+	nearest := -1.0
+	priority := "normal"
+	for i, h := range hazards {
+		if counts[h.Type] == 0 {
+			types = append(types, h.Type)
+		}
+		counts[h.Type]++
+		ids[i] = h.ID.String()
+		if km := distanceKm(rcpt.Settings, h); nearest < 0 || km < nearest {
+			nearest = km
+		}
+		if h.Severity == models.HazardSeverityHigh {
+			priority = "high"
+		}
+	}
+
+	counted := make([]string, len(types))
+	for i, t := range types {
+		counted[i] = r.hazards(t, counts[t])
+	}
+	var msg *message
+	if len(types) == 1 {
+		msg = r.message(digestTemplate, types[0])
+	} else {
+		msg = r.message(digestTemplate, defaultTemplate)
+	}
+	vars := map[string]string{
+		"count":    fmt.Sprint(len(hazards)),
+		"hazards":  strings.Join(counted, ", "),
+		"distance": r.distance(nearest),
+	}
+
+	return &models.NotificationPayload{
+		Title: r.text(msg.Title, len(hazards), vars),
+		Body:  r.text(msg.Body, len(hazards), vars),
+		Data: map[string]interface{}{
+			"hazard_ids": ids,
+		},
+		Priority: priority,
+	}
+}
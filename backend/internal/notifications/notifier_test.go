@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roadeye/backend/pkg/models"
+)
+
+func TestQuietUntil(t *testing.T) {
+	now := time.Date(2024, 6, 10, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		settings  models.NotificationSettings
+		wantQuiet bool
+		wantEnd   time.Time
+	}{
+		{
+			name:     "no quiet hours",
+			settings: models.NotificationSettings{TimeZone: "UTC"},
+		},
+		{
+			name:      "inside, wrapping past midnight",
+			settings:  models.NotificationSettings{QuietHours: &models.QuietHours{Start: "22:00", End: "07:00"}, TimeZone: "UTC"},
+			wantQuiet: true,
+			wantEnd:   time.Date(2024, 6, 11, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "outside",
+			settings: models.NotificationSettings{QuietHours: &models.QuietHours{Start: "08:00", End: "18:00"}, TimeZone: "UTC"},
+		},
+		{
+			name:      "unknown time zone is UTC",
+			settings:  models.NotificationSettings{QuietHours: &models.QuietHours{Start: "23:00", End: "23:45"}, TimeZone: "Mars/Olympus_Mons"},
+			wantQuiet: true,
+			wantEnd:   time.Date(2024, 6, 10, 23, 45, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end, quiet := quietUntil(&tt.settings, now)
+			if quiet != tt.wantQuiet || !end.Equal(tt.wantEnd) {
+				t.Errorf("quietUntil() = %v, %v, want %v, %v", end, quiet, tt.wantEnd, tt.wantQuiet)
+			}
+		})
+	}
+}
+
+func TestCommentAlert(t *testing.T) {
+	hazard := &models.Hazard{Type: models.HazardTypePothole}
+	long := strings.Repeat("a", commentExcerptLen+10)
+
+	tests := []struct {
+		locale    string
+		body      string
+		wantTitle string
+		wantBody  string
+	}{
+		{"en", "Still there this morning", "New comment on a hazard you follow", "maria wrote: Still there this morning"},
+		{"fr-CA", "Toujours là", "Nouveau commentaire sur un danger que vous suivez", "maria a écrit : Toujours là"},
+		{"pt-BR", "Ainda lá", "New comment on a hazard you follow", "maria wrote: Ainda lá"},
+		{"en", long, "New comment on a hazard you follow", "maria wrote: " + long[:commentExcerptLen-1] + "…"},
+	}
+	for _, tt := range tests {
+		comment := &models.Comment{Username: "maria", Body: tt.body}
+		got := commentAlert(hazard, comment, &Recipient{Locale: tt.locale, Units: models.UnitsMetric})
+		if got.Title != tt.wantTitle || got.Body != tt.wantBody {
+			t.Errorf("commentAlert() in %s = %q, %q, want %q, %q", tt.locale, got.Title, got.Body, tt.wantTitle, tt.wantBody)
+		}
+	}
+}
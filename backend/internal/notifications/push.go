@@ -0,0 +1,22 @@
+package notifications
+
+import (
+	"context"
+	"log"
+
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// Pusher sends a push notification to a user's devices.
+type Pusher interface {
+	Push(ctx context.Context, tokens []*models.DeviceToken, payload *models.NotificationPayload) error
+}
+
+// LogPusher writes pushes to the log instead of sending them, until a push
+// provider is configured.
+type LogPusher struct{}
+
+func (LogPusher) Push(ctx context.Context, tokens []*models.DeviceToken, payload *models.NotificationPayload) error {
+	log.Printf("Push to %d devices: %s: %s", len(tokens), payload.Title, payload.Body)
+	return nil
+}
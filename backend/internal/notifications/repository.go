@@ -0,0 +1,307 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// MaxRadiusKm is the largest alert radius a user may choose.
+const MaxRadiusKm = 50
+
+var ErrUserNotFound = errors.New("user not found")
+
+type Repository struct {
+	db *sql.DB
+	// defaultRadiusKm is the radius of users who have not saved settings.
+	defaultRadiusKm float64
+}
+
+func NewRepository(db *sql.DB, defaultRadiusKm float64) *Repository {
+	return &Repository{db: db, defaultRadiusKm: defaultRadiusKm}
+}
+
+const settingsColumns = `latitude, longitude, radius_km, types, min_severity, quiet_start, quiet_end,
+		       time_zone, push, email, updated_at`
+
+func scanSettings(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*models.NotificationSettings, error) {
+	s := &models.NotificationSettings{}
+	var types []string
+	var quietStart, quietEnd sql.NullInt32
+	dest := append(extra,
+		&s.Latitude, &s.Longitude, &s.RadiusKm, pq.Array(&types), &s.MinSeverity, &quietStart, &quietEnd,
+		&s.TimeZone, &s.Channels.Push, &s.Channels.Email, &s.UpdatedAt,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	s.Types = make([]models.HazardType, len(types))
+	for i, t := range types {
+		s.Types[i] = models.HazardType(t)
+	}
+	if quietStart.Valid && quietEnd.Valid {
+		s.QuietHours = &models.QuietHours{
+			Start: models.ClockString(int(quietStart.Int32)),
+			End:   models.ClockString(int(quietEnd.Int32)),
+		}
+	}
+	return s, nil
+}
+
+// DefaultSettings are the settings of users who have not saved any.
+func (r *Repository) DefaultSettings() *models.NotificationSettings {
+	return &models.NotificationSettings{
+		RadiusKm:    r.defaultRadiusKm,
+		Types:       []models.HazardType{},
+		MinSeverity: models.HazardSeverityLow,
+		TimeZone:    "UTC",
+		Channels:    models.NotificationChannels{Push: true},
+	}
+}
+
+// Settings returns the settings of userID, or the defaults if none were
+// saved.
+func (r *Repository) Settings(ctx context.Context, userID uuid.UUID) (*models.NotificationSettings, error) {
+	s, err := scanSettings(r.db.QueryRowContext(ctx, `
+		SELECT `+settingsColumns+` FROM notification_settings WHERE user_id = $1
+	`, userID))
+	if err == sql.ErrNoRows {
+		return r.DefaultSettings(), nil
+	}
+	return s, err
+}
+
+// PutSettings replaces the settings of userID.
+func (r *Repository) PutSettings(ctx context.Context, userID uuid.UUID, s *models.NotificationSettings) (*models.NotificationSettings, error) {
+	var quietStart, quietEnd *int
+	if s.QuietHours != nil {
+		start, end, err := s.QuietHours.Minutes()
+		if err != nil {
+			return nil, err
+		}
+		quietStart, quietEnd = &start, &end
+	}
+
+	query := `
+		INSERT INTO notification_settings (user_id, latitude, longitude, radius_km, types, min_severity,
+			quiet_start, quiet_end, time_zone, push, email)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11 FROM users WHERE id = $1
+		ON CONFLICT (user_id) DO UPDATE SET
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			radius_km = EXCLUDED.radius_km,
+			types = EXCLUDED.types,
+			min_severity = EXCLUDED.min_severity,
+			quiet_start = EXCLUDED.quiet_start,
+			quiet_end = EXCLUDED.quiet_end,
+			time_zone = EXCLUDED.time_zone,
+			push = EXCLUDED.push,
+			email = EXCLUDED.email
+		RETURNING ` + settingsColumns
+
+	saved, err := scanSettings(r.db.QueryRowContext(ctx, query,
+		userID, s.Latitude, s.Longitude, s.RadiusKm, pq.Array(typeStrings(s.Types)), s.MinSeverity,
+		quietStart, quietEnd, s.TimeZone, s.Channels.Push, s.Channels.Email))
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return saved, err
+}
+
+// Recipient is a user to alert about a hazard.
+type Recipient struct {
+	UserID uuid.UUID
+	Email  string
+	// Locale and Units are what the user's alerts are written in.
+	Locale   string
+	Units    models.UnitSystem
+	Settings *models.NotificationSettings
+}
+
+const recipientColumns = `s.user_id, u.email, u.locale, u.units, s.latitude, s.longitude, s.radius_km, s.types, s.min_severity,
+		       s.quiet_start, s.quiet_end, s.time_zone, s.push, s.email, s.updated_at`
+
+func scanRecipient(row interface{ Scan(...interface{}) error }) (*Recipient, error) {
+	rcpt := &Recipient{}
+	settings, err := scanSettings(row, &rcpt.UserID, &rcpt.Email, &rcpt.Locale, &rcpt.Units)
+	if err != nil {
+		return nil, err
+	}
+	rcpt.Settings = settings
+	return rcpt, nil
+}
+
+// Recipients returns up to limit users whose settings match hazard, nearest
+// first: it lies within their radius, has one of their types and at least
+// their minimum severity. The reporter, banned users and users with every
+// channel off are left out. Quiet hours are left to the caller.
+func (r *Repository) Recipients(ctx context.Context, hazard *models.Hazard, limit int) ([]*Recipient, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH hazard AS (SELECT ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography AS location)
+		SELECT `+recipientColumns+`
+		FROM notification_settings s
+		JOIN users u ON u.id = s.user_id, hazard h
+		WHERE ST_DWithin(s.location, h.location, $3)
+		  AND ST_DWithin(s.location, h.location, s.radius_km * 1000)
+		  AND (cardinality(s.types) = 0 OR $4 = ANY(s.types))
+		  AND array_position(ARRAY['low', 'medium', 'high'], $5::text)
+		      >= array_position(ARRAY['low', 'medium', 'high'], s.min_severity::text)
+		  AND (s.push OR s.email)
+		  AND s.user_id <> $6
+		  AND u.status <> 'banned'
+		ORDER BY ST_Distance(s.location, h.location), s.user_id
+		LIMIT $7
+	`, hazard.Longitude, hazard.Latitude, MaxRadiusKm*1000.0,
+		string(hazard.Type), string(hazard.Severity), hazard.UserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []*Recipient
+	for rows.Next() {
+		rcpt, err := scanRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, rcpt)
+	}
+	return recipients, rows.Err()
+}
+
+// Recipient returns userID as a recipient, or nil if they have no location
+// or may no longer be alerted.
+func (r *Repository) Recipient(ctx context.Context, userID uuid.UUID) (*Recipient, error) {
+	rcpt, err := scanRecipient(r.db.QueryRowContext(ctx, `
+		SELECT `+recipientColumns+`
+		FROM notification_settings s JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = $1 AND s.location IS NOT NULL AND u.status <> 'banned'
+	`, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rcpt, err
+}
+
+// Participants returns up to limit users taking part in the discussion of
+// hazardID, its reporter and its commenters, other than except. Users
+// without settings get the default channels; banned users and users with
+// every channel off are left out.
+func (r *Repository) Participants(ctx context.Context, hazardID, except uuid.UUID, limit int) ([]*Recipient, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT u.id, u.email, u.locale, u.units, s.latitude, s.longitude, COALESCE(s.radius_km, $3),
+		       COALESCE(s.types, '{}'), COALESCE(s.min_severity, 'low'), s.quiet_start, s.quiet_end,
+		       COALESCE(s.time_zone, 'UTC'), COALESCE(s.push, true), COALESCE(s.email, false), s.updated_at
+		FROM users u LEFT JOIN notification_settings s ON s.user_id = u.id
+		WHERE u.id IN (
+			SELECT user_id FROM hazards WHERE id = $1
+			UNION
+			SELECT user_id FROM hazard_comments WHERE hazard_id = $1 AND deleted_at IS NULL
+		)
+		  AND u.id <> $2
+		  AND u.status <> 'banned'
+		  AND (s.user_id IS NULL OR s.push OR s.email)
+		ORDER BY u.id
+		LIMIT $4
+	`, hazardID, except, r.defaultRadiusKm, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []*Recipient
+	for rows.Next() {
+		rcpt, err := scanRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		participants = append(participants, rcpt)
+	}
+	return participants, rows.Err()
+}
+
+// DigestHazards returns the hazards among ids that are still shown, oldest
+// first.
+func (r *Repository) DigestHazards(ctx context.Context, ids []uuid.UUID) ([]*models.Hazard, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, type, severity, latitude, longitude, status, created_at
+		FROM hazards
+		WHERE id = ANY($1) AND deleted_at IS NULL AND hidden_at IS NULL
+		ORDER BY created_at
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hazards []*models.Hazard
+	for rows.Next() {
+		h := &models.Hazard{}
+		err := rows.Scan(&h.ID, &h.UserID, &h.Type, &h.Severity, &h.Latitude, &h.Longitude, &h.Status, &h.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		hazards = append(hazards, h)
+	}
+	return hazards, rows.Err()
+}
+
+// DeviceTokens returns the push tokens registered by userID.
+func (r *Repository) DeviceTokens(ctx context.Context, userID uuid.UUID) ([]*models.DeviceToken, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, token, platform, created_at, updated_at
+		FROM device_tokens WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.DeviceToken
+	for rows.Next() {
+		t := &models.DeviceToken{}
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Token, &t.Platform, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Record stores an alert in the user's notifications, as not sent yet.
+func (r *Repository) Record(ctx context.Context, userID, hazardID uuid.UUID, payload *models.NotificationPayload) (uuid.UUID, error) {
+	var data []byte
+	if payload.Data != nil {
+		var err error
+		if data, err = json.Marshal(payload.Data); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	var id uuid.UUID
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, hazard_id, title, body, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, hazardID, payload.Title, payload.Body, data).Scan(&id)
+	return id, err
+}
+
+// MarkSent records that the notifications went out by push or email.
+func (r *Repository) MarkSent(ctx context.Context, ids []uuid.UUID) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE notifications SET sent = TRUE WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
+
+func typeStrings(types []models.HazardType) []string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = string(t)
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+package notifications
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	// FallbackLocale is used for users whose language has no templates, and
+	// for any template missing from theirs.
+	FallbackLocale = "en"
+
+	// digestTemplate is the template of several alerts sent as one.
+	digestTemplate = "hazard.digest"
+	// defaultTemplate stands in for hazard types without a template of
+	// their own.
+	defaultTemplate = "default"
+
+	kmPerMile = 1.609344
+)
+
+//go:embed templates/*.json
+var templateFiles embed.FS
+
+// templates are the bundled catalogs, checked when the package loads.
+var templates = mustLoadTemplates()
+
+// plural is a text with a form per CLDR plural category ("one", "other",
+// ...). In the files it may also be a plain string, used for any count.
+type plural map[string]string
+
+func (p *plural) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*p = plural{"other": s}
+		return nil
+	}
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+	if _, ok := forms["other"]; !ok {
+		return errors.New(`plural text has no "other" form`)
+	}
+	*p = forms
+	return nil
+}
+
+type message struct {
+	Title plural `json:"title"`
+	Body  plural `json:"body"`
+}
+
+// catalog holds the templates of one locale. Events holds a message per
+// event type and hazard type, with defaultTemplate for the other types.
+type catalog struct {
+	DecimalSeparator string                           `json:"decimal_separator"`
+	Severities       map[models.HazardSeverity]string `json:"severities"`
+	Hazards          map[models.HazardType]plural     `json:"hazards"`
+	Units            map[models.UnitSystem]string     `json:"units"`
+	Events           map[string]map[string]*message   `json:"events"`
+
+	// lang picks the plural rule, which every bundled language must have.
+	lang string
+}
+
+func mustLoadTemplates() map[string]*catalog {
+	files, err := templateFiles.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]*catalog, len(files))
+	for _, f := range files {
+		data, err := templateFiles.ReadFile(path.Join("templates", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		c := &catalog{}
+		if err := json.Unmarshal(data, c); err != nil {
+			panic(fmt.Sprintf("notification templates %s: %v", f.Name(), err))
+		}
+		name := strings.TrimSuffix(f.Name(), ".json")
+		c.lang = strings.SplitN(name, "-", 2)[0]
+		if pluralRules[c.lang] == nil {
+			panic("notification templates " + f.Name() + ": no plural rule for " + c.lang)
+		}
+		catalogs[name] = c
+	}
+	if catalogs[FallbackLocale] == nil {
+		panic("notification templates: no " + FallbackLocale + ".json")
+	}
+	return catalogs
+}
+
+var localePattern = regexp.MustCompile(`^([a-zA-Z]{2,3})(?:[-_]([a-zA-Z]{2}|[0-9]{3}))?$`)
+
+// NormalizeLocale returns locale as a language tag with an optional region,
+// such as "en" or "pt-BR", and false if it is not of that form. Any such
+// tag is accepted; languages without templates get FallbackLocale.
+func NormalizeLocale(locale string) (string, bool) {
+	m := localePattern.FindStringSubmatch(locale)
+	if m == nil {
+		return "", false
+	}
+	tag := strings.ToLower(m[1])
+	if m[2] != "" {
+		tag += "-" + strings.ToUpper(m[2])
+	}
+	return tag, true
+}
+
+// renderer writes alerts in one user's language and units.
+type renderer struct {
+	// lang is the language of the first catalog, which picks the plural
+	// rule. Languages without templates get FallbackLocale's.
+	lang  string
+	units models.UnitSystem
+	// catalogs are tried in order: the locale, its language, the fallback.
+	catalogs []*catalog
+}
+
+func newRenderer(locale string, units models.UnitSystem) *renderer {
+	r := &renderer{units: units}
+	if !units.Valid() {
+		r.units = models.UnitsMetric
+	}
+
+	names := []string{FallbackLocale}
+	if tag, ok := NormalizeLocale(locale); ok {
+		names = []string{tag, strings.SplitN(tag, "-", 2)[0], FallbackLocale}
+	}
+	seen := make(map[*catalog]bool)
+	for _, name := range names {
+		if c := templates[name]; c != nil && !seen[c] {
+			seen[c] = true
+			r.catalogs = append(r.catalogs, c)
+		}
+	}
+	r.lang = r.catalogs[0].lang
+	return r
+}
+
+// message returns the template for event and hazardType, preferring the
+// user's language over a template specific to the type.
+func (r *renderer) message(event string, hazardType models.HazardType) *message {
+	for _, c := range r.catalogs {
+		if msg := c.Events[event][string(hazardType)]; msg != nil {
+			return msg
+		}
+		if msg := c.Events[event][defaultTemplate]; msg != nil {
+			return msg
+		}
+	}
+	return &message{}
+}
+
+// text fills in the form of p for count n with vars, written {name} in the
+// template.
+func (r *renderer) text(p plural, n int, vars map[string]string) string {
+	form, ok := p[pluralCategory(r.lang, n)]
+	if !ok {
+		form = p["other"]
+	}
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(form)
+}
+
+func (r *renderer) severity(s models.HazardSeverity) string {
+	for _, c := range r.catalogs {
+		if name, ok := c.Severities[s]; ok {
+			return name
+		}
+	}
+	return string(s)
+}
+
+// hazards returns n hazards of type t, counted, such as "2 potholes".
+func (r *renderer) hazards(t models.HazardType, n int) string {
+	for _, c := range r.catalogs {
+		if p, ok := c.Hazards[t]; ok {
+			return r.text(p, n, map[string]string{"count": fmt.Sprint(n)})
+		}
+	}
+	return fmt.Sprintf("%d %s", n, t)
+}
+
+// distance writes km in the user's units to one decimal place.
+func (r *renderer) distance(km float64) string {
+	value := km
+	if r.units == models.UnitsImperial {
+		value = km / kmPerMile
+	}
+	s := fmt.Sprintf("%.1f", value)
+
+	for _, c := range r.catalogs {
+		if c.DecimalSeparator != "" {
+			s = strings.Replace(s, ".", c.DecimalSeparator, 1)
+			break
+		}
+	}
+	for _, c := range r.catalogs {
+		if unit, ok := c.Units[r.units]; ok {
+			return strings.ReplaceAll(unit, "{value}", s)
+		}
+	}
+	return s
+}
+
+// pluralRules give the CLDR plural category of a whole number, for the
+// bundled languages only. A language gets a rule when its templates are
+// added; until then it is written in FallbackLocale with its rule.
+var pluralRules = map[string]func(n int) string{
+	"en": oneOrOther,
+	"de": oneOrOther,
+	"es": oneOrOther,
+	"fr": func(n int) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+}
+
+func oneOrOther(n int) string {
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// pluralCategory returns the plural category of n in lang, using
+// FallbackLocale's rule for languages without templates.
+func pluralCategory(lang string, n int) string {
+	rule, ok := pluralRules[lang]
+	if !ok {
+		rule = pluralRules[FallbackLocale]
+	}
+	return rule(n)
+}
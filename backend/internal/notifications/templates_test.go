@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/roadeye/backend/pkg/models"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+		wantOK bool
+	}{
+		{"en", "en", true},
+		{"EN", "en", true},
+		{"pt-BR", "pt-BR", true},
+		{"pt_br", "pt-BR", true},
+		{"es-419", "es-419", true},
+		{"fil", "fil", true},
+		{"", "", false},
+		{"e", "", false},
+		{"english", "", false},
+		{"en-", "", false},
+		{"en-GBR", "", false},
+		{"en-US-x-private", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := NormalizeLocale(tt.locale)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("NormalizeLocale(%q) = %q, %v, want %q, %v", tt.locale, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"en", 0, "other"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"de", 1, "one"},
+		{"de", 0, "other"},
+		{"es", 1, "one"},
+		{"es", 21, "other"},
+		{"fr", 0, "one"},
+		{"fr", 1, "one"},
+		{"fr", 2, "other"},
+		// Languages without templates use the English rule
+		{"pt", 0, "other"},
+		{"pt", 1, "one"},
+		{"ru", 21, "other"},
+		{"", 1, "one"},
+	}
+	for _, tt := range tests {
+		if got := pluralCategory(tt.lang, tt.n); got != tt.want {
+			t.Errorf("pluralCategory(%q, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPluralRulesCoverTemplates(t *testing.T) {
+	for name, c := range templates {
+		if pluralRules[c.lang] == nil {
+			t.Errorf("templates %s have no plural rule for %q", name, c.lang)
+		}
+	}
+	for lang := range pluralRules {
+		if templates[lang] == nil {
+			t.Errorf("plural rule for %q, which has no templates", lang)
+		}
+	}
+}
+
+func TestRendererDistance(t *testing.T) {
+	tests := []struct {
+		locale string
+		units  models.UnitSystem
+		km     float64
+		want   string
+	}{
+		{"en", models.UnitsMetric, 1.25, "1.2 km"},
+		{"en", models.UnitsMetric, 0.04, "0.0 km"},
+		{"en", models.UnitsImperial, 1.609344, "1.0 mi"},
+		{"en", models.UnitsImperial, 10, "6.2 mi"},
+		{"de", models.UnitsMetric, 2.5, "2,5 km"},
+		{"fr-CA", models.UnitsImperial, 3.2, "2,0 mi"},
+		{"es-MX", models.UnitsMetric, 12.34, "12,3 km"},
+		{"pt-BR", models.UnitsMetric, 2.5, "2.5 km"},
+		{"en", "furlongs", 2.5, "2.5 km"},
+		{"not a locale!", models.UnitsMetric, 2.5, "2.5 km"},
+	}
+	for _, tt := range tests {
+		r := newRenderer(tt.locale, tt.units)
+		if got := r.distance(tt.km); got != tt.want {
+			t.Errorf("distance(%v) in %s, %s = %q, want %q", tt.km, tt.locale, tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestRendererHazards(t *testing.T) {
+	tests := []struct {
+		locale string
+		n      int
+		want   string
+	}{
+		{"en", 1, "1 pothole"},
+		{"en", 0, "0 potholes"},
+		{"fr", 0, "0 nid-de-poule"},
+		{"fr", 2, "2 nids-de-poule"},
+		{"de-AT", 3, "3 Schlaglöcher"},
+		{"pt-BR", 1, "1 pothole"},
+	}
+	for _, tt := range tests {
+		r := newRenderer(tt.locale, models.UnitsMetric)
+		if got := r.hazards(models.HazardTypePothole, tt.n); got != tt.want {
+			t.Errorf("hazards(pothole, %d) in %s = %q, want %q", tt.n, tt.locale, got, tt.want)
+		}
+	}
+}
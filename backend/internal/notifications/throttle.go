@@ -0,0 +1,189 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/roadeye/backend/internal/ratelimit"
+)
+
+const (
+	// DedupeTTL is how long a user is remembered as alerted about a hazard.
+	DedupeTTL = 7 * 24 * time.Hour
+	// CommentBurst is how long after an alert about a comment further
+	// comments on the same hazard go to the inbox only.
+	CommentBurst = 30 * time.Minute
+
+	throttlePrefix = "notify:"
+	digestDueKey   = throttlePrefix + "digest:due"
+)
+
+type ThrottleConfig struct {
+	// MaxPerHazard caps how many users are alerted about one hazard.
+	MaxPerHazard int
+	// MaxPerUserHour caps the pushes and emails a user receives per hour;
+	// a digest counts once.
+	MaxPerUserHour int
+	// DigestWindow is how long after an alert further alerts to the same
+	// user are held back and then sent together as one digest. Zero sends
+	// every alert on its own.
+	DigestWindow time.Duration
+}
+
+// Throttle keeps the alerting counters in Redis, so that several worker
+// replicas, which all receive every event, agree on them.
+type Throttle struct {
+	client  *redis.Client
+	limiter ratelimit.Backend
+	config  ThrottleConfig
+}
+
+func NewThrottle(client *redis.Client, config ThrottleConfig) *Throttle {
+	return &Throttle{client: client, limiter: ratelimit.NewRedisBackend(client), config: config}
+}
+
+// Claim reports whether userID may be alerted about hazardID, or about a
+// comment by its ID: true the first time only, whichever replica asks.
+func (t *Throttle) Claim(ctx context.Context, userID, hazardID uuid.UUID) (bool, error) {
+	return t.client.SetNX(ctx, throttlePrefix+"seen:"+userID.String()+":"+hazardID.String(), 1, DedupeTTL).Result()
+}
+
+// ClaimComments reports whether userID may be alerted about a comment on
+// hazardID: true for the first comment of a burst, whichever replica asks.
+func (t *Throttle) ClaimComments(ctx context.Context, userID, hazardID uuid.UUID) (bool, error) {
+	return t.client.SetNX(ctx, throttlePrefix+"comments:"+userID.String()+":"+hazardID.String(), 1, CommentBurst).Result()
+}
+
+// CountForHazard counts one more user alerted about hazardID and reports
+// whether that is still within the per-hazard cap.
+func (t *Throttle) CountForHazard(ctx context.Context, hazardID uuid.UUID) (bool, error) {
+	key := throttlePrefix + "hazard:" + hazardID.String()
+	pipe := t.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, DedupeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return count.Val() <= int64(t.config.MaxPerHazard), nil
+}
+
+// AllowSend counts an alert going out to userID and reports whether it is
+// within the hourly cap.
+func (t *Throttle) AllowSend(ctx context.Context, userID uuid.UUID) (bool, error) {
+	result, err := t.limiter.Allow(ctx, throttlePrefix+"sent:"+userID.String(), t.config.MaxPerUserHour, time.Hour)
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+// DigestItem is an alert held back for a digest.
+type DigestItem struct {
+	NotificationID uuid.UUID `json:"n"`
+	HazardID       uuid.UUID `json:"h"`
+}
+
+// Hold reports whether an alert to userID is held back for a digest. The
+// first alert opens a window of DigestWindow and is not held. Alerts
+// arriving while the window is open are queued; the digest is due a
+// DigestWindow after the first of them.
+func (t *Throttle) Hold(ctx context.Context, userID uuid.UUID, item *DigestItem) (bool, error) {
+	if t.config.DigestWindow <= 0 {
+		return false, nil
+	}
+	opened, err := t.client.SetNX(ctx, throttlePrefix+"burst:"+userID.String(), 1, t.config.DigestWindow).Result()
+	if err != nil || opened {
+		return false, err
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+
+	if err := t.queue(ctx, userID, [][]byte{data}, time.Now().Add(t.config.DigestWindow)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Defer queues alerts to userID for a digest due at due, or earlier if one
+// is already due before then.
+func (t *Throttle) Defer(ctx context.Context, userID uuid.UUID, items []*DigestItem, due time.Time) error {
+	values := make([][]byte, 0, len(items))
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		values = append(values, data)
+	}
+	return t.queue(ctx, userID, values, due)
+}
+
+func (t *Throttle) queue(ctx context.Context, userID uuid.UUID, values [][]byte, due time.Time) error {
+	if len(values) == 0 {
+		return nil
+	}
+	key := throttlePrefix + "digest:" + userID.String()
+	pipe := t.client.TxPipeline()
+	for _, data := range values {
+		pipe.RPush(ctx, key, data)
+	}
+	pipe.Expire(ctx, key, DedupeTTL)
+	pipe.ZAddNX(ctx, digestDueKey, redis.Z{Score: float64(due.Unix()), Member: userID.String()})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DueDigests returns the users whose digest is due. Each user is handed to
+// one caller only.
+func (t *Throttle) DueDigests(ctx context.Context, now time.Time) ([]uuid.UUID, error) {
+	members, err := t.client.ZRangeByScore(ctx, digestDueKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []uuid.UUID
+	for _, member := range members {
+		// Whoever removes the member owns the digest
+		removed, err := t.client.ZRem(ctx, digestDueKey, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			continue
+		}
+		if userID, err := uuid.Parse(member); err == nil {
+			due = append(due, userID)
+		}
+	}
+	return due, nil
+}
+
+// TakeDigest returns and clears the alerts held for userID.
+func (t *Throttle) TakeDigest(ctx context.Context, userID uuid.UUID) ([]*DigestItem, error) {
+	key := throttlePrefix + "digest:" + userID.String()
+	pipe := t.client.TxPipeline()
+	values := pipe.LRange(ctx, key, 0, -1)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	items := make([]*DigestItem, 0, len(values.Val()))
+	for _, v := range values.Val() {
+		item := &DigestItem{}
+		if err := json.Unmarshal([]byte(v), item); err == nil {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
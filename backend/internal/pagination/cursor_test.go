@@ -0,0 +1,42 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := Cursor{CreatedAt: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), ID: uuid.New()}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		wantErr bool
+	}{
+		{"round trip", want.Encode(), false},
+		{"empty", "", true},
+		{"not base64", "***", true},
+		{"not JSON", base64.RawURLEncoding.EncodeToString([]byte("{")), true},
+		{"no ID", Cursor{CreatedAt: want.CreatedAt}.Encode(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.cursor)
+			if tt.wantErr {
+				if err != ErrInvalidCursor {
+					t.Errorf("DecodeCursor() error = %v, want ErrInvalidCursor", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeCursor() error = %v", err)
+			}
+			if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+				t.Errorf("DecodeCursor() = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
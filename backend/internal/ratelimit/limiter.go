@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roadeye/backend/internal/auth"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+
+	keyPrefix = "ratelimit:"
+)
+
+// Headers lists the response headers set by Middleware, for CORS exposure.
+var Headers = []string{HeaderLimit, HeaderRemaining, HeaderReset, HeaderPolicy, HeaderRetryAfter}
+
+// Policy allows Requests per sliding Window. Name separates the counters of
+// policies applied to the same client.
+type Policy struct {
+	Name     string
+	Requests int
+	Window   time.Duration
+}
+
+// Result is the outcome of counting one request against a policy.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the oldest counted request leaves the window
+	// and frees a slot.
+	Reset time.Duration
+}
+
+// Backend counts requests in a sliding window.
+type Backend interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (*Result, error)
+}
+
+type Limiter struct {
+	backend Backend
+}
+
+func NewLimiter(backend Backend) *Limiter {
+	return &Limiter{backend: backend}
+}
+
+// Middleware enforces p per client. Authenticated requests are counted per
+// user when it runs after AuthMiddleware; anonymous requests, and all
+// requests when it runs before, are counted per client address as set by
+// RealIP. API keys are
+// counted per key, against their own per-minute limit when they have one.
+// If the backend fails, requests are let through.
+func (l *Limiter) Middleware(p Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := p
+			if principal, ok := auth.GetPrincipalFromContext(r.Context()); ok && principal.APIKey != nil {
+				if limit := principal.APIKey.RateLimit; limit != nil {
+					p.Requests = *limit
+					p.Window = time.Minute
+				}
+			}
+			policyHeader := fmt.Sprintf("%d;w=%d", p.Requests, int(p.Window.Seconds()))
+			key := keyPrefix + p.Name + ":" + clientKey(r)
+
+			result, err := l.backend.Allow(r.Context(), key, p.Requests, p.Window)
+			if err != nil {
+				log.Printf("Rate limiter unavailable, allowing request: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := seconds(result.Reset)
+			w.Header().Set(HeaderLimit, strconv.Itoa(p.Requests))
+			w.Header().Set(HeaderRemaining, strconv.Itoa(result.Remaining))
+			w.Header().Set(HeaderReset, strconv.Itoa(reset))
+			w.Header().Set(HeaderPolicy, policyHeader)
+
+			if !result.Allowed {
+				w.Header().Set(HeaderRetryAfter, strconv.Itoa(reset))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientKey(r *http.Request) string {
+	if principal, ok := auth.GetPrincipalFromContext(r.Context()); ok && principal.APIKey != nil {
+		return "key:" + principal.APIKey.ID.String()
+	}
+	if userID, ok := auth.GetUserIDFromContext(r.Context()); ok {
+		return "user:" + userID.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds rounds d up to whole seconds, as the headers require.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareHeaders(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	backend := NewMemoryBackend()
+	backend.now = func() time.Time { return now }
+	limiter := NewLimiter(backend)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	login := limiter.Middleware(Policy{Name: "login", Requests: 2, Window: 15 * time.Minute})(ok)
+	register := limiter.Middleware(Policy{Name: "register", Requests: 2, Window: time.Hour})(ok)
+
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		addr          string
+		wantStatus    int
+		wantRemaining string
+		wantReset     string
+		wantPolicy    string
+		wantRetry     string
+	}{
+		{"first login", login, "203.0.113.7:5000", http.StatusOK, "1", "900", "2;w=900", ""},
+		{"second login", login, "203.0.113.7:5001", http.StatusOK, "0", "900", "2;w=900", ""},
+		{"third login", login, "203.0.113.7:5002", http.StatusTooManyRequests, "0", "900", "2;w=900", "900"},
+		{"other address", login, "198.51.100.1:5000", http.StatusOK, "1", "900", "2;w=900", ""},
+		{"register has its own counter", register, "203.0.113.7:5003", http.StatusOK, "1", "3600", "2;w=3600", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			r.RemoteAddr = tt.addr
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			headers := map[string]string{
+				HeaderLimit:      "2",
+				HeaderRemaining:  tt.wantRemaining,
+				HeaderReset:      tt.wantReset,
+				HeaderPolicy:     tt.wantPolicy,
+				HeaderRetryAfter: tt.wantRetry,
+			}
+			for name, want := range headers {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryBackend forgets idle clients.
+const sweepInterval = time.Minute
+
+type window struct {
+	times  []time.Time
+	length time.Duration
+}
+
+// MemoryBackend counts requests in process memory. Counters are not shared,
+// so it only suits single-node deployments and tests.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		windows:   make(map[string]*window),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (b *MemoryBackend) Allow(ctx context.Context, key string, limit int, length time.Duration) (*Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.lastSweep) >= sweepInterval {
+		b.sweep(now)
+	}
+
+	w, ok := b.windows[key]
+	if !ok {
+		w = &window{length: length}
+		b.windows[key] = w
+	}
+	w.times = prune(w.times, now.Add(-length))
+
+	result := &Result{}
+	if len(w.times) < limit {
+		w.times = append(w.times, now)
+		result.Allowed = true
+	}
+	result.Remaining = limit - len(w.times)
+	result.Reset = length
+	if len(w.times) > 0 {
+		result.Reset = w.times[0].Add(length).Sub(now)
+	}
+
+	return result, nil
+}
+
+// sweep drops clients whose requests have all left their window, so the map
+// does not grow with every address ever seen.
+func (b *MemoryBackend) sweep(now time.Time) {
+	for key, w := range b.windows {
+		if len(w.times) == 0 || !w.times[len(w.times)-1].After(now.Add(-w.length)) {
+			delete(b.windows, key)
+		}
+	}
+	b.lastSweep = now
+}
+
+// prune drops the times at or before cutoff from the sorted slice.
+func prune(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBackendAllow(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	b := NewMemoryBackend()
+	b.now = func() time.Time { return now }
+	b.lastSweep = start
+
+	// Three requests per minute; each step is a request at an offset from
+	// the start
+	tests := []struct {
+		at            time.Duration
+		key           string
+		wantAllowed   bool
+		wantRemaining int
+		wantReset     time.Duration
+	}{
+		{0, "a", true, 2, time.Minute},
+		{10 * time.Second, "a", true, 1, 50 * time.Second},
+		{20 * time.Second, "a", true, 0, 40 * time.Second},
+		{30 * time.Second, "a", false, 0, 30 * time.Second},
+		// Other keys have their own window
+		{30 * time.Second, "b", true, 2, time.Minute},
+		// The first request leaves the window and frees one slot
+		{time.Minute, "a", true, 0, 10 * time.Second},
+		{time.Minute + time.Second, "a", false, 0, 9 * time.Second},
+		{3 * time.Minute, "a", true, 2, time.Minute},
+	}
+	for i, tt := range tests {
+		now = start.Add(tt.at)
+		got, err := b.Allow(ctx, tt.key, 3, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Allowed != tt.wantAllowed || got.Remaining != tt.wantRemaining || got.Reset != tt.wantReset {
+			t.Errorf("request %d at %v = %+v, want allowed %v, remaining %d, reset %v",
+				i+1, tt.at, got, tt.wantAllowed, tt.wantRemaining, tt.wantReset)
+		}
+	}
+}
+
+func TestMemoryBackendSweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := NewMemoryBackend()
+	b.now = func() time.Time { return now }
+	b.lastSweep = now
+
+	b.Allow(ctx, "idle", 3, time.Minute)
+	now = now.Add(30 * time.Second)
+	b.Allow(ctx, "busy", 3, 10*time.Minute)
+
+	now = now.Add(sweepInterval)
+	b.Allow(ctx, "new", 3, time.Minute)
+	if _, ok := b.windows["idle"]; ok {
+		t.Error("idle client kept after its window ended")
+	}
+	if _, ok := b.windows["busy"]; !ok {
+		t.Error("client with requests in its window swept")
+	}
+}
@@ -0,0 +1,409 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+// DeletedUserID is the placeholder account that deleted users' hazards are
+// handed to, so community reports outlive their authors.
+var DeletedUserID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+var ErrNotFound = errors.New("user not found")
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// PasswordHash returns the user's password hash, empty for users who sign in
+// only through an identity provider.
+func (r *Repository) PasswordHash(ctx context.Context, userID uuid.UUID) (string, error) {
+	var hash string
+	err := r.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return hash, err
+}
+
+// SetPreferences saves the user's locale and units.
+func (r *Repository) SetPreferences(ctx context.Context, userID uuid.UUID, p *models.UserPreferences) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET locale = $2, units = $3 WHERE id = $1
+	`, userID, p.Locale, p.Units)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ScheduleDeletion marks the account for deletion at the given time. An
+// earlier request keeps its schedule.
+func (r *Repository) ScheduleDeletion(ctx context.Context, userID uuid.UUID, at time.Time) (time.Time, error) {
+	var scheduled time.Time
+	err := r.db.QueryRowContext(ctx, `
+		UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, $2)
+		WHERE id = $1
+		RETURNING deletion_scheduled_at
+	`, userID, at).Scan(&scheduled)
+	if err == sql.ErrNoRows {
+		return time.Time{}, ErrNotFound
+	}
+	return scheduled, err
+}
+
+// CancelDeletion reports whether a pending deletion was cancelled.
+func (r *Repository) CancelDeletion(ctx context.Context, userID uuid.UUID) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE users SET deletion_scheduled_at = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+	`, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// PurgeDue deletes accounts whose grace period ended before now and returns
+// how many were deleted. An account that fails to purge is logged and left
+// for the next run, without holding up the others.
+func (r *Repository) PurgeDue(ctx context.Context, now time.Time) (int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id FROM users
+		WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		if err := r.purge(ctx, id, now); err != nil {
+			log.Printf("Failed to purge deleted user %s: %v", id, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purge hands the user's hazards and hazard history to DeletedUserID,
+// stripping anything that identifies the author, and deletes the user.
+// Everything else personal cascades with the user row, and the moderation
+// records that name them lose the reference. It does nothing if the
+// deletion was cancelled in the meantime.
+func (r *Repository) purge(ctx context.Context, userID uuid.UUID, now time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var due bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $2
+		FROM users WHERE id = $1
+		FOR UPDATE
+	`, userID, now).Scan(&due)
+	if err == sql.ErrNoRows || (err == nil && !due) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE hazards SET user_id = $2, reported_by = NULL, client_key = NULL
+		WHERE user_id = $1
+	`, userID, DeletedUserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE hazard_events SET actor_id = $2 WHERE actor_id = $1
+	`, userID, DeletedUserID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Export collects everything held about the user.
+func (r *Repository) Export(ctx context.Context, userID uuid.UUID) (*models.DataExport, error) {
+	export := &models.DataExport{GeneratedAt: time.Now().UTC()}
+
+	var err error
+	if export.Profile, err = r.exportProfile(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Hazards, err = r.exportHazards(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Verifications, err = r.exportVerifications(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Comments, err = r.exportComments(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Notifications, err = r.exportNotifications(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.DeviceTokens, err = r.exportDeviceTokens(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	export.LocationHistory = make([]*models.LocationRecord, 0, len(export.Hazards)+len(export.Verifications))
+	for _, h := range export.Hazards {
+		recordedAt := h.CreatedAt
+		if h.CapturedAt != nil {
+			recordedAt = *h.CapturedAt
+		}
+		export.LocationHistory = append(export.LocationHistory, &models.LocationRecord{
+			Latitude: h.Latitude, Longitude: h.Longitude, RecordedAt: recordedAt, Source: "report",
+		})
+	}
+	for _, v := range export.Verifications {
+		export.LocationHistory = append(export.LocationHistory, &models.LocationRecord{
+			Latitude: v.Latitude, Longitude: v.Longitude, RecordedAt: v.CreatedAt, Source: "verification",
+		})
+	}
+	sort.Slice(export.LocationHistory, func(i, j int) bool {
+		return export.LocationHistory[i].RecordedAt.Before(export.LocationHistory[j].RecordedAt)
+	})
+
+	return export, nil
+}
+
+func (r *Repository) exportProfile(ctx context.Context, userID uuid.UUID) (*models.ExportProfile, error) {
+	p := &models.ExportProfile{}
+	var totpEnabledAt sql.NullTime
+	err := r.db.QueryRowContext(ctx, `
+		SELECT id, username, email, points, role, avatar, locale, units, status, totp_enabled_at,
+		       deletion_scheduled_at, created_at, updated_at
+		FROM users WHERE id = $1
+	`, userID).Scan(
+		&p.ID, &p.Username, &p.Email, &p.Points, &p.Role, &p.Avatar, &p.Locale, &p.Units, &p.Status, &totpEnabledAt,
+		&p.DeletionScheduledAt, &p.CreatedAt, &p.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.TwoFactorEnabled = totpEnabledAt.Valid
+
+	if p.NotificationSettings, err = r.exportNotificationSettings(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT provider, email, created_at FROM user_identities
+		WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	p.Identities = []*models.ExportIdentity{}
+	for rows.Next() {
+		identity := &models.ExportIdentity{}
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		p.Identities = append(p.Identities, identity)
+	}
+	return p, rows.Err()
+}
+
+func (r *Repository) exportNotificationSettings(ctx context.Context, userID uuid.UUID) (*models.NotificationSettings, error) {
+	s := &models.NotificationSettings{}
+	var types []string
+	var quietStart, quietEnd sql.NullInt32
+	err := r.db.QueryRowContext(ctx, `
+		SELECT latitude, longitude, radius_km, types, min_severity, quiet_start, quiet_end,
+		       time_zone, push, email, updated_at
+		FROM notification_settings WHERE user_id = $1
+	`, userID).Scan(
+		&s.Latitude, &s.Longitude, &s.RadiusKm, pq.Array(&types), &s.MinSeverity, &quietStart, &quietEnd,
+		&s.TimeZone, &s.Channels.Push, &s.Channels.Email, &s.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range types {
+		s.Types = append(s.Types, models.HazardType(t))
+	}
+	if quietStart.Valid && quietEnd.Valid {
+		s.QuietHours = &models.QuietHours{
+			Start: models.ClockString(int(quietStart.Int32)),
+			End:   models.ClockString(int(quietEnd.Int32)),
+		}
+	}
+	return s, nil
+}
+
+// exportHazards includes reports the user deleted or moderators hid.
+func (r *Repository) exportHazards(ctx context.Context, userID uuid.UUID) ([]*models.Hazard, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, type, latitude, longitude, image_url, severity, description,
+		       is_verified, verify_count, reported_by, bearing, lane, status, captured_at,
+		       hidden_at, created_at, updated_at
+		FROM hazards WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hazards := []*models.Hazard{}
+	for rows.Next() {
+		h := &models.Hazard{}
+		err := rows.Scan(
+			&h.ID, &h.UserID, &h.Type, &h.Latitude, &h.Longitude, &h.ImageURL, &h.Severity, &h.Description,
+			&h.IsVerified, &h.VerifyCount, &h.ReportedBy, &h.Bearing, &h.Lane, &h.Status, &h.CapturedAt,
+			&h.HiddenAt, &h.CreatedAt, &h.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		hazards = append(hazards, h)
+	}
+	return hazards, rows.Err()
+}
+
+func (r *Repository) exportVerifications(ctx context.Context, userID uuid.UUID) ([]*models.ExportVerification, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT v.hazard_id, h.latitude, h.longitude, v.created_at
+		FROM hazard_verifications v JOIN hazards h ON h.id = v.hazard_id
+		WHERE v.user_id = $1
+		ORDER BY v.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []*models.ExportVerification{}
+	for rows.Next() {
+		v := &models.ExportVerification{}
+		if err := rows.Scan(&v.HazardID, &v.Latitude, &v.Longitude, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		verifications = append(verifications, v)
+	}
+	return verifications, rows.Err()
+}
+
+func (r *Repository) exportComments(ctx context.Context, userID uuid.UUID) ([]*models.Comment, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT c.id, c.hazard_id, c.user_id, u.username, c.body, c.edited, c.created_at, c.updated_at
+		FROM hazard_comments c JOIN users u ON u.id = c.user_id
+		WHERE c.user_id = $1
+		ORDER BY c.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*models.Comment{}
+	for rows.Next() {
+		c := &models.Comment{}
+		err := rows.Scan(&c.ID, &c.HazardID, &c.UserID, &c.Username, &c.Body, &c.Edited, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (r *Repository) exportNotifications(ctx context.Context, userID uuid.UUID) ([]*models.Notification, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, hazard_id, title, body, COALESCE(data::text, ''), sent, read_at, created_at
+		FROM notifications WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*models.Notification{}
+	for rows.Next() {
+		n := &models.Notification{}
+		if err := rows.Scan(&n.ID, &n.UserID, &n.HazardID, &n.Title, &n.Body, &n.Data, &n.Sent, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func (r *Repository) exportDeviceTokens(ctx context.Context, userID uuid.UUID) ([]*models.DeviceToken, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, user_id, token, platform, created_at, updated_at
+		FROM device_tokens WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.DeviceToken{}
+	for rows.Next() {
+		t := &models.DeviceToken{}
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Token, &t.Platform, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
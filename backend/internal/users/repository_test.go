@@ -0,0 +1,170 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+// testDB connects to TEST_DATABASE_URL, a throwaway database with every
+// migration applied. Tests that need it are skipped when it is not set.
+// Hazards with history can never be deleted, so their rows are left behind.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createTestUser(t *testing.T, db *sql.DB, deletionScheduledAt *time.Time) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, email, password_hash, deletion_scheduled_at)
+		VALUES ($1, $2, $3, '', $4)
+	`, id, "test_"+id.String()[:8], id.String()+"@example.com", deletionScheduledAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPurgeDueHandsHistoryToPlaceholder(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	due := now.Add(-time.Hour)
+
+	author := createTestUser(t, db, &due)
+	verifier := createTestUser(t, db, &due)
+	other := createTestUser(t, db, nil)
+
+	var hazardID uuid.UUID
+	err := db.QueryRow(`
+		INSERT INTO hazards (user_id, type, latitude, longitude, severity, reported_by)
+		VALUES ($1, 'pothole', 52.52, 13.405, 'high', 'Author Name')
+		RETURNING id
+	`, author).Scan(&hazardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO hazard_events (hazard_id, actor_id, action) VALUES
+			($1, $2, 'created'), ($1, $3, 'verified'), ($1, $4, 'verified')
+	`, hazardID, author, verifier, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepository(db)
+	if _, err := repo.PurgeDue(ctx, now); err != nil {
+		t.Fatalf("PurgeDue() error = %v", err)
+	}
+
+	for _, id := range []uuid.UUID{author, verifier} {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		if exists {
+			t.Errorf("user %s was not purged", id)
+		}
+	}
+
+	var owner uuid.UUID
+	var reportedBy sql.NullString
+	if err := db.QueryRow(`SELECT user_id, reported_by FROM hazards WHERE id = $1`, hazardID).Scan(&owner, &reportedBy); err != nil {
+		t.Fatal(err)
+	}
+	if owner != DeletedUserID || reportedBy.Valid {
+		t.Errorf("hazard owner = %s, reported_by = %v, want %s and NULL", owner, reportedBy, DeletedUserID)
+	}
+
+	rows, err := db.Query(`SELECT action, actor_id FROM hazard_events WHERE hazard_id = $1 ORDER BY actor_id = $2`, hazardID, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var actors []uuid.UUID
+	for rows.Next() {
+		var action string
+		var actor uuid.UUID
+		if err := rows.Scan(&action, &actor); err != nil {
+			t.Fatal(err)
+		}
+		actors = append(actors, actor)
+	}
+	if len(actors) != 3 || actors[0] != DeletedUserID || actors[1] != DeletedUserID || actors[2] != other {
+		t.Errorf("event actors = %v, want the purged users replaced by %s and %s kept", actors, DeletedUserID, other)
+	}
+}
+
+func TestHazardEventsAppendOnly(t *testing.T) {
+	db := testDB(t)
+	author := createTestUser(t, db, nil)
+	other := createTestUser(t, db, nil)
+
+	var hazardID, eventID uuid.UUID
+	err := db.QueryRow(`
+		INSERT INTO hazards (user_id, type, latitude, longitude, severity)
+		VALUES ($1, 'debris', 48.85, 2.35, 'low')
+		RETURNING id
+	`, author).Scan(&hazardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow(`
+		INSERT INTO hazard_events (hazard_id, actor_id, action) VALUES ($1, $2, 'created') RETURNING id
+	`, hazardID, author).Scan(&eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		args    []interface{}
+		allowed bool
+	}{
+		{"change the action", `UPDATE hazard_events SET action = 'deleted' WHERE id = $1`, []interface{}{eventID}, false},
+		{"hand to another user", `UPDATE hazard_events SET actor_id = $2 WHERE id = $1`, []interface{}{eventID, other}, false},
+		{"clear the actor", `UPDATE hazard_events SET actor_id = NULL WHERE id = $1`, []interface{}{eventID}, false},
+		{"hand to the placeholder and change the data", `UPDATE hazard_events SET actor_id = $2, data = '{}' WHERE id = $1`, []interface{}{eventID, DeletedUserID}, false},
+		{"delete the event", `DELETE FROM hazard_events WHERE id = $1`, []interface{}{eventID}, false},
+		{"delete the hazard", `DELETE FROM hazards WHERE id = $1`, []interface{}{hazardID}, false},
+		{"delete the actor", `DELETE FROM users WHERE id = $1`, []interface{}{author}, false},
+		{"hand to the placeholder", `UPDATE hazard_events SET actor_id = $2 WHERE id = $1`, []interface{}{eventID, DeletedUserID}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+
+			_, err = tx.Exec(tt.query, tt.args...)
+			if tt.allowed && err != nil {
+				t.Errorf("refused: %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("allowed")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	batchSize    = 20
+	// claimLease is how long a claimed delivery is left alone before another
+	// worker may retry it. It must exceed the request timeout.
+	claimLease = 5 * time.Minute
+
+	baseBackoff = time.Minute
+	maxBackoff  = 6 * time.Hour
+
+	maxErrorLength = 500
+)
+
+var errBlockedAddress = errors.New("webhook address is not publicly routable")
+
+type DispatcherConfig struct {
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts int
+	// DisableAfter is how many failed attempts in a row disable a
+	// subscription.
+	DisableAfter int
+	Timeout      time.Duration
+	// AllowPrivateNetworks lets webhooks reach loopback and private
+	// addresses, for local development only.
+	AllowPrivateNetworks bool
+}
+
+// Dispatcher sends queued deliveries from the worker.
+type Dispatcher struct {
+	repo   *Repository
+	config DispatcherConfig
+	client *http.Client
+}
+
+func NewDispatcher(repo *Repository, config DispatcherConfig) *Dispatcher {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivateNetworks {
+		dialer.Control = refusePrivateAddress
+	}
+
+	return &Dispatcher{
+		repo:   repo,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// No proxy, so that the dialer sees the real destination
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: config.Timeout,
+				MaxIdleConnsPerHost: 2,
+			},
+			// A redirect could point anywhere; treat it as a failure
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// refusePrivateAddress runs after DNS resolution, so it also catches
+// public names that resolve to internal addresses.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errBlockedAddress
+	}
+	return nil
+}
+
+// Run sends due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there is a backlog
+		for d.dispatchBatch(ctx) == batchSize {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchBatch sends one batch of due deliveries concurrently and returns
+// how many there were.
+func (d *Dispatcher) dispatchBatch(ctx context.Context) int {
+	jobs, err := d.repo.claim(ctx, batchSize, claimLease)
+	if err != nil {
+		log.Printf("Failed to claim webhook deliveries: %v", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer wg.Done()
+			d.deliver(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+
+	return len(jobs)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, j *job) {
+	status, err := d.send(ctx, j)
+	if err == nil {
+		if err := d.repo.succeeded(ctx, j.ID, status); err != nil {
+			log.Printf("Failed to record webhook delivery %s: %v", j.ID, err)
+		}
+		return
+	}
+
+	var responseStatus *int
+	if status != 0 {
+		responseStatus = &status
+	}
+	message := err.Error()
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength]
+	}
+
+	var retryAt *time.Time
+	if j.Attempts < d.config.MaxAttempts {
+		t := time.Now().Add(Backoff(j.Attempts))
+		retryAt = &t
+	}
+
+	disabled, err := d.repo.failed(ctx, j.ID, responseStatus, message, retryAt, d.config.DisableAfter)
+	if err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", j.ID, err)
+		return
+	}
+	if disabled {
+		log.Printf("Disabled webhook after %d consecutive failures (delivery %s)", d.config.DisableAfter, j.ID)
+	}
+}
+
+// send posts the payload and returns the response status, which is 0 when
+// no response was received.
+func (d *Dispatcher) send(ctx context.Context, j *job) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.URL, bytes.NewReader(j.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "RoadEye-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, j.EventType)
+	req.Header.Set(HeaderDelivery, j.ID.String())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(j.Secret, timestamp, j.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Backoff returns the wait before retrying after attempt failed: a minute,
+// doubling with each attempt up to six hours, plus up to 10% jitter so that
+// an outage does not end in a thundering herd.
+func Backoff(attempt int) time.Duration {
+	wait := maxBackoff
+	if attempt < 20 {
+		wait = baseBackoff << (attempt - 1)
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/10+1))
+}
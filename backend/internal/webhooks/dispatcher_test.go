@@ -0,0 +1,85 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, maxBackoff},
+		{19, maxBackoff},
+		{20, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := Backoff(tt.attempt)
+			if got < tt.base || got > tt.base+tt.base/10 {
+				t.Fatalf("Backoff(%d) = %v, want between %v and %v", tt.attempt, got, tt.base, tt.base+tt.base/10)
+			}
+		}
+	}
+}
+
+func TestRefusePrivateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		refused bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"127.0.0.1:443", true},
+		{"[::1]:443", true},
+		{"10.0.0.5:443", true},
+		{"172.16.0.1:443", true},
+		{"192.168.1.1:443", true},
+		{"169.254.169.254:80", true},
+		{"[fd00::1]:443", true},
+		{"[fe80::1]:443", true},
+		{"0.0.0.0:443", true},
+		{"224.0.0.1:443", true},
+		{"example.com:443", true},
+		{"no port", true},
+	}
+	for _, tt := range tests {
+		err := refusePrivateAddress("tcp", tt.address, nil)
+		if got := err != nil; got != tt.refused {
+			t.Errorf("refusePrivateAddress(%q) = %v, want refused %v", tt.address, err, tt.refused)
+		}
+	}
+}
+
+func TestDispatcherRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tests := []struct {
+		allowPrivate bool
+		wantBlocked  bool
+	}{
+		{false, true},
+		{true, false},
+	}
+	for _, tt := range tests {
+		d := NewDispatcher(nil, DispatcherConfig{Timeout: time.Second, AllowPrivateNetworks: tt.allowPrivate})
+		resp, err := d.client.Post(server.URL, "application/json", strings.NewReader("{}"))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if blocked := errors.Is(err, errBlockedAddress); blocked != tt.wantBlocked {
+			t.Errorf("AllowPrivateNetworks %v: error = %v, want blocked %v", tt.allowPrivate, err, tt.wantBlocked)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/roadeye/backend/internal/events"
+	"github.com/roadeye/backend/internal/geo"
+	"github.com/roadeye/backend/pkg/models"
+)
+
+const (
+	MaxSubscriptionsPerUser = 10
+
+	DefaultDeliveryLimit = 50
+	MaxDeliveryLimit     = 100
+)
+
+var (
+	ErrNotFound     = errors.New("webhook not found")
+	ErrInvalidArea  = errors.New("invalid webhook area")
+	ErrLimitReached = errors.New("webhook limit reached")
+)
+
+// Events are the event types sent to subscribers. Comments are left out;
+// partners only follow the hazards themselves.
+var Events = []events.EventType{
+	events.HazardCreated,
+	events.HazardUpdated,
+	events.HazardResolved,
+	events.HazardDeleted,
+	events.HazardHidden,
+}
+
+const subscriptionColumns = `id, user_id, url, ST_AsGeoJSON(area), types, min_severity,
+		       consecutive_failures, disabled_at, created_at, updated_at`
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, payload, status, attempts,
+		       next_attempt_at, response_status, last_error, redelivery_of, delivered_at, created_at`
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*models.WebhookSubscription, error) {
+	sub := &models.WebhookSubscription{}
+	var area string
+	var types []string
+	err := row.Scan(
+		&sub.ID, &sub.UserID, &sub.URL, &area, pq.Array(&types), &sub.MinSeverity,
+		&sub.ConsecutiveFailures, &sub.DisabledAt, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(area), &sub.Area); err != nil {
+		return nil, err
+	}
+	sub.Types = make([]models.HazardType, len(types))
+	for i, t := range types {
+		sub.Types[i] = models.HazardType(t)
+	}
+	return sub, nil
+}
+
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var payload []byte
+	err := row.Scan(
+		&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.ResponseStatus, &d.LastError, &d.RedeliveryOf, &d.DeliveredAt, &d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return d, nil
+}
+
+// checkArea makes sure PostGIS accepts the area, which rules out
+// self-intersecting rings the handler cannot easily spot.
+func (r *Repository) checkArea(ctx context.Context, area string) error {
+	var valid bool
+	err := r.db.QueryRowContext(ctx, `SELECT ST_IsValid(ST_GeomFromEWKT($1))`, area).Scan(&valid)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidArea
+	}
+	return nil
+}
+
+// Create adds a subscription for userID with a new signing secret, which is
+// returned only here.
+func (r *Repository) Create(ctx context.Context, userID uuid.UUID, req *models.WebhookCreate, area [][]geo.Point) (*models.WebhookCreated, error) {
+	ewkt := geo.PolygonEWKT(area)
+	if err := r.checkArea(ctx, ewkt); err != nil {
+		return nil, err
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	minSeverity := models.HazardSeverityLow
+	if req.MinSeverity != nil {
+		minSeverity = *req.MinSeverity
+	}
+
+	query := `
+		INSERT INTO webhook_subscriptions (user_id, url, secret, area, types, min_severity)
+		SELECT $1, $2, $3, ST_GeomFromEWKT($4), $5, $6
+		WHERE (SELECT COUNT(*) FROM webhook_subscriptions WHERE user_id = $1) < $7
+		RETURNING ` + subscriptionColumns
+
+	sub, err := scanSubscription(r.db.QueryRowContext(ctx, query,
+		userID, req.URL, secret, ewkt, pq.Array(typeStrings(req.Types)), minSeverity, MaxSubscriptionsPerUser))
+	if err == sql.ErrNoRows {
+		return nil, ErrLimitReached
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebhookCreated{WebhookSubscription: sub, Secret: secret}, nil
+}
+
+// List returns the subscriptions of userID, oldest first.
+func (r *Repository) List(ctx context.Context, userID uuid.UUID) ([]*models.WebhookSubscription, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+subscriptionColumns+` FROM webhook_subscriptions
+		WHERE user_id = $1
+		ORDER BY created_at ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*models.WebhookSubscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// Get returns subscription id if it belongs to userID.
+func (r *Repository) Get(ctx context.Context, id, userID uuid.UUID) (*models.WebhookSubscription, error) {
+	sub, err := scanSubscription(r.db.QueryRowContext(ctx, `
+		SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE id = $1 AND user_id = $2
+	`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return sub, err
+}
+
+// Update changes the given fields of a subscription of userID. area is nil
+// to keep the current one. Disabling a subscription fails its pending
+// deliveries; re-enabling it clears the failure count.
+func (r *Repository) Update(ctx context.Context, id, userID uuid.UUID, req *models.WebhookUpdate, area [][]geo.Point) (*models.WebhookSubscription, error) {
+	var ewkt *string
+	if area != nil {
+		s := geo.PolygonEWKT(area)
+		if err := r.checkArea(ctx, s); err != nil {
+			return nil, err
+		}
+		ewkt = &s
+	}
+	var types interface{}
+	if req.Types != nil {
+		types = pq.Array(typeStrings(req.Types))
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE webhook_subscriptions SET
+			url = COALESCE($3, url),
+			area = COALESCE(ST_GeomFromEWKT($4), area),
+			types = COALESCE($5, types),
+			min_severity = COALESCE($6, min_severity),
+			disabled_at = CASE
+				WHEN $7::boolean IS NULL THEN disabled_at
+				WHEN $7 THEN NULL
+				ELSE COALESCE(disabled_at, CURRENT_TIMESTAMP)
+			END,
+			consecutive_failures = CASE WHEN $7 THEN 0 ELSE consecutive_failures END
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + subscriptionColumns
+
+	sub, err := scanSubscription(tx.QueryRowContext(ctx, query,
+		id, userID, req.URL, ewkt, types, req.MinSeverity, req.Enabled))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sub.DisabledAt != nil {
+		if err := failPending(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return sub, tx.Commit()
+}
+
+// Delete removes a subscription of userID along with its delivery log.
+func (r *Repository) Delete(ctx context.Context, id, userID uuid.UUID) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Deliveries returns the delivery log of a subscription, newest first,
+// optionally only those with status.
+func (r *Repository) Deliveries(ctx context.Context, subscriptionID uuid.UUID, status *models.WebhookDeliveryStatus, limit, offset int) ([]*models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = $1 AND ($2::text IS NULL OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3 OFFSET $4
+	`, subscriptionID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver queues a fresh copy of a delivery, with the original payload,
+// for the worker to send as soon as possible.
+func (r *Repository) Redeliver(ctx context.Context, subscriptionID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, redelivery_of)
+		SELECT subscription_id, event_id, event_type, payload, id
+		FROM webhook_deliveries WHERE id = $1 AND subscription_id = $2
+		RETURNING ` + deliveryColumns
+
+	d, err := scanDelivery(r.db.QueryRowContext(ctx, query, deliveryID, subscriptionID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
+
+// Enqueue records a delivery of event for every enabled subscription it
+// matches, and returns how many were queued. Events already queued, by
+// this or another worker, are skipped.
+func (r *Repository) Enqueue(ctx context.Context, event *events.HazardEvent) (int64, error) {
+	if event.Hazard == nil || !isWebhookEvent(event.Type) {
+		return 0, nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE disabled_at IS NULL
+		  AND ST_Intersects(area, ST_SetSRID(ST_MakePoint($4, $5), 4326))
+		  AND (cardinality(types) = 0 OR $6 = ANY(types))
+		  AND array_position(ARRAY['low', 'medium', 'high'], $7::text)
+		      >= array_position(ARRAY['low', 'medium', 'high'], min_severity::text)
+		ON CONFLICT (subscription_id, event_id) WHERE redelivery_of IS NULL DO NOTHING
+	`, event.ID, string(event.Type), payload, event.Hazard.Longitude, event.Hazard.Latitude,
+		string(event.Hazard.Type), string(event.Hazard.Severity))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func isWebhookEvent(eventType events.EventType) bool {
+	for _, t := range Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// job is a claimed delivery together with where to send it.
+type job struct {
+	ID        uuid.UUID
+	EventType string
+	Payload   []byte
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// claim takes up to limit due deliveries of enabled subscriptions and
+// pushes their next attempt back by lease, so that a worker that dies
+// mid-delivery leaves them to be retried rather than lost.
+func (r *Repository) claim(ctx context.Context, limit int, lease time.Duration) ([]*job, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH due AS (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhook_subscriptions s ON s.id = d.subscription_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= CURRENT_TIMESTAMP AND s.disabled_at IS NULL
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries d
+			SET attempts = d.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+			FROM due WHERE d.id = due.id
+			RETURNING d.id, d.subscription_id, d.event_type, d.payload, d.attempts
+		)
+		SELECT c.id, c.event_type, c.payload, c.attempts, s.url, s.secret
+		FROM claimed c JOIN webhook_subscriptions s ON s.id = c.subscription_id
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*job
+	for rows.Next() {
+		j := &job{}
+		if err := rows.Scan(&j.ID, &j.EventType, &j.Payload, &j.Attempts, &j.URL, &j.Secret); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// succeeded marks a delivery done and resets its subscription's failure
+// count.
+func (r *Repository) succeeded(ctx context.Context, deliveryID uuid.UUID, responseStatus int) error {
+	_, err := r.db.ExecContext(ctx, `
+		WITH d AS (
+			UPDATE webhook_deliveries SET
+				status = 'succeeded', response_status = $2, last_error = NULL,
+				next_attempt_at = NULL, delivered_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING subscription_id
+		)
+		UPDATE webhook_subscriptions SET consecutive_failures = 0
+		WHERE id = (SELECT subscription_id FROM d) AND consecutive_failures > 0
+	`, deliveryID, responseStatus)
+	return err
+}
+
+// failed records a failed attempt. The delivery is retried at retryAt, or
+// given up on when retryAt is nil. The subscription is disabled once it
+// has failed disableAfter times in a row; failed reports whether this
+// attempt disabled it.
+func (r *Repository) failed(ctx context.Context, deliveryID uuid.UUID, responseStatus *int, message string, retryAt *time.Time, disableAfter int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var subscriptionID uuid.UUID
+	err = tx.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries SET
+			status = CASE WHEN $4::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+			response_status = $2, last_error = $3, next_attempt_at = $4
+		WHERE id = $1
+		RETURNING subscription_id
+	`, deliveryID, responseStatus, message, retryAt).Scan(&subscriptionID)
+	if err != nil {
+		return false, err
+	}
+
+	var disabled bool
+	err = tx.QueryRowContext(ctx, `
+		UPDATE webhook_subscriptions SET
+			consecutive_failures = consecutive_failures + 1,
+			disabled_at = CASE
+				WHEN disabled_at IS NULL AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP
+				ELSE disabled_at
+			END
+		WHERE id = $1
+		RETURNING disabled_at IS NOT NULL AND consecutive_failures = $2
+	`, subscriptionID, disableAfter).Scan(&disabled)
+	if err != nil {
+		return false, err
+	}
+
+	if disabled {
+		if err := failPending(ctx, tx, subscriptionID); err != nil {
+			return false, err
+		}
+	}
+
+	return disabled, tx.Commit()
+}
+
+// failPending gives up on the queued deliveries of a disabled subscription,
+// which would otherwise all be sent at once when it is re-enabled. They can
+// still be redelivered by hand.
+func failPending(ctx context.Context, tx *sql.Tx, subscriptionID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = 'failed', next_attempt_at = NULL,
+			last_error = COALESCE(last_error, 'Webhook disabled')
+		WHERE subscription_id = $1 AND status = 'pending'
+	`, subscriptionID)
+	return err
+}
+
+func typeStrings(types []models.HazardType) []string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = string(t)
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers sent with every delivery. The signature covers the timestamp and
+// the body, joined by a dot, so receivers can reject replays by checking
+// that the timestamp is recent.
+const (
+	HeaderEvent     = "X-RoadEye-Event"
+	HeaderDelivery  = "X-RoadEye-Delivery"
+	HeaderTimestamp = "X-RoadEye-Timestamp"
+	HeaderSignature = "X-RoadEye-Signature"
+
+	secretPrefix    = "whsec_"
+	signaturePrefix = "sha256="
+)
+
+// GenerateSecret returns a new random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns the X-RoadEye-Signature value for body sent at timestamp, in
+// Unix seconds: "sha256=" followed by the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package webhooks
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{
+			name:      "event",
+			secret:    "whsec_test",
+			timestamp: 1700000000,
+			body:      `{"event":"hazard.created"}`,
+			want:      "sha256=580228d89d7ea8749a7f19015d0baa349116ef917ae7b4775206280c765ec95e",
+		},
+		{
+			name:      "empty body",
+			secret:    "whsec_test",
+			timestamp: 1700000000,
+			body:      "",
+			want:      "sha256=5967f3c560522fa40cf2876ebc3c3a08551dd6959aaade3b413460591895bdcc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	base := Sign("whsec_test", 1700000000, []byte("{}"))
+	if Sign("whsec_other", 1700000000, []byte("{}")) == base {
+		t.Error("signature does not depend on the secret")
+	}
+	if Sign("whsec_test", 1700000001, []byte("{}")) == base {
+		t.Error("signature does not depend on the timestamp")
+	}
+}
@@ -0,0 +1,7 @@
+-- Hazard lifecycle status
+ALTER TABLE hazards
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'resolved'));
+
+CREATE INDEX IF NOT EXISTS idx_hazards_status ON hazards(status);
+CREATE INDEX IF NOT EXISTS idx_hazards_created_at_id ON hazards(created_at DESC, id DESC);
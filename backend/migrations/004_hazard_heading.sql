@@ -0,0 +1,4 @@
+-- Direction of travel and lane at report time, used by drive mode
+ALTER TABLE hazards
+    ADD COLUMN IF NOT EXISTS bearing DOUBLE PRECISION CHECK (bearing >= 0 AND bearing < 360),
+    ADD COLUMN IF NOT EXISTS lane VARCHAR(20) CHECK (lane IN ('left', 'center', 'right', 'shoulder', 'all'));
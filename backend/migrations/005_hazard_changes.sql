@@ -0,0 +1,34 @@
+-- Append-only change log backing delta sync for offline clients.
+-- txid orders changes by transaction so readers can skip rows whose
+-- transaction may still be in flight.
+CREATE TABLE IF NOT EXISTS hazard_changes (
+    seq BIGSERIAL PRIMARY KEY,
+    txid BIGINT NOT NULL DEFAULT txid_current(),
+    hazard_id UUID NOT NULL,
+    op VARCHAR(10) NOT NULL CHECK (op IN ('upsert', 'delete')),
+    location GEOGRAPHY(POINT, 4326) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_hazard_changes_txid_seq ON hazard_changes(txid, seq);
+CREATE INDEX idx_hazard_changes_location ON hazard_changes USING GIST(location);
+
+CREATE OR REPLACE FUNCTION record_hazard_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (OLD.id, 'delete', OLD.location);
+        RETURN OLD;
+    END IF;
+
+    INSERT INTO hazard_changes (hazard_id, op, location) VALUES (NEW.id, 'upsert', NEW.location);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER hazard_change_trigger AFTER INSERT OR UPDATE OR DELETE ON hazards
+    FOR EACH ROW EXECUTE FUNCTION record_hazard_change();
+
+-- Seed the log with hazards that existed before it
+INSERT INTO hazard_changes (hazard_id, op, location)
+SELECT id, 'upsert', location FROM hazards ORDER BY created_at;
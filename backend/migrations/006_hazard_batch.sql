@@ -0,0 +1,7 @@
+-- Client-generated idempotency keys and capture times for offline reports
+ALTER TABLE hazards
+    ADD COLUMN IF NOT EXISTS client_key VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_hazards_user_client_key
+    ON hazards(user_id, client_key) WHERE client_key IS NOT NULL;
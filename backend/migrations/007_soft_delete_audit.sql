@@ -0,0 +1,59 @@
+-- User roles
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'moderator', 'authority', 'admin'));
+
+-- Soft delete for hazards
+ALTER TABLE hazards
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS delete_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_hazards_deleted_at ON hazards(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Append-only audit history for hazards. Neither hazards nor users with
+-- history can be deleted until it is dealt with, as the history itself can
+-- never be changed.
+CREATE TABLE IF NOT EXISTS hazard_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    hazard_id UUID NOT NULL REFERENCES hazards(id) ON DELETE RESTRICT,
+    actor_id UUID REFERENCES users(id) ON DELETE RESTRICT,
+    action VARCHAR(30) NOT NULL CHECK (action IN ('created', 'updated', 'verified', 'status_changed', 'deleted')),
+    data JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_hazard_events_hazard_id ON hazard_events(hazard_id, created_at);
+CREATE INDEX idx_hazard_events_actor_id ON hazard_events(actor_id);
+
+-- Existing hazards start their history at creation
+INSERT INTO hazard_events (hazard_id, actor_id, action, created_at)
+SELECT id, user_id, 'created', created_at FROM hazards;
+
+CREATE OR REPLACE FUNCTION prevent_hazard_event_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'hazard_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER hazard_events_append_only BEFORE UPDATE OR DELETE ON hazard_events
+    FOR EACH ROW EXECUTE FUNCTION prevent_hazard_event_changes();
+
+-- Soft-deleted hazards reach the change log as deletes
+CREATE OR REPLACE FUNCTION record_hazard_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (OLD.id, 'delete', OLD.location);
+        RETURN OLD;
+    END IF;
+
+    IF NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (NEW.id, 'delete', NEW.location);
+    ELSE
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (NEW.id, 'upsert', NEW.location);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,30 @@
+-- Discussion threads on hazards
+CREATE TABLE IF NOT EXISTS hazard_comments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    hazard_id UUID NOT NULL REFERENCES hazards(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    edited BOOLEAN DEFAULT FALSE,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_hazard_comments_hazard_id ON hazard_comments(hazard_id, created_at, id);
+CREATE INDEX idx_hazard_comments_user_id ON hazard_comments(user_id);
+
+CREATE TRIGGER update_hazard_comments_updated_at BEFORE UPDATE ON hazard_comments
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Abuse reports on comments
+CREATE TABLE IF NOT EXISTS comment_reports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    comment_id UUID NOT NULL REFERENCES hazard_comments(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason VARCHAR(30) NOT NULL CHECK (reason IN ('spam', 'offensive', 'harassment', 'off_topic', 'other')),
+    details TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(comment_id, user_id)
+);
+
+CREATE INDEX idx_comment_reports_comment_id ON comment_reports(comment_id);
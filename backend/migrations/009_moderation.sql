@@ -0,0 +1,60 @@
+-- Hazards hidden by moderators or by accumulated flags
+ALTER TABLE hazards ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP WITH TIME ZONE;
+
+-- Banned accounts
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS ban_reason TEXT;
+
+-- User flags on hazards
+CREATE TABLE IF NOT EXISTS hazard_flags (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    hazard_id UUID NOT NULL REFERENCES hazards(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason VARCHAR(30) NOT NULL CHECK (reason IN ('spam', 'fake', 'offensive_image', 'duplicate')),
+    details TEXT,
+    weight INTEGER NOT NULL,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolution VARCHAR(20),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(hazard_id, user_id)
+);
+
+CREATE INDEX idx_hazard_flags_open ON hazard_flags(hazard_id) WHERE resolved_at IS NULL;
+
+-- Audit trail of moderator decisions
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    hazard_id UUID REFERENCES hazards(id) ON DELETE SET NULL,
+    target_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('dismiss', 'hide', 'delete', 'ban_user')),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_moderation_actions_hazard_id ON moderation_actions(hazard_id);
+CREATE INDEX idx_moderation_actions_moderator_id ON moderation_actions(moderator_id);
+
+ALTER TABLE hazard_events DROP CONSTRAINT IF EXISTS hazard_events_action_check;
+ALTER TABLE hazard_events ADD CONSTRAINT hazard_events_action_check
+    CHECK (action IN ('created', 'updated', 'verified', 'status_changed', 'deleted', 'flagged', 'hidden', 'unhidden'));
+
+-- Hidden hazards reach the change log as deletes
+CREATE OR REPLACE FUNCTION record_hazard_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (OLD.id, 'delete', OLD.location);
+        RETURN OLD;
+    END IF;
+
+    IF NEW.deleted_at IS NOT NULL OR NEW.hidden_at IS NOT NULL THEN
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (NEW.id, 'delete', NEW.location);
+    ELSE
+        INSERT INTO hazard_changes (hazard_id, op, location) VALUES (NEW.id, 'upsert', NEW.location);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
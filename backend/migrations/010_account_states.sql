@@ -0,0 +1,24 @@
+-- Account states replace the single banned flag
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'suspended', 'shadow_banned', 'banned')),
+    ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS status_reason TEXT;
+
+UPDATE users SET status = 'banned', status_reason = ban_reason WHERE banned_at IS NOT NULL;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS banned_at,
+    DROP COLUMN IF EXISTS ban_reason;
+
+-- Hazards from shadow-banned users are shown only to their reporter
+ALTER TABLE hazards ADD COLUMN IF NOT EXISTS shadowed BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Daily report quotas count a user's recent hazards
+CREATE INDEX idx_hazards_user_created_at ON hazards(user_id, created_at DESC);
+
+ALTER TABLE moderation_actions ALTER COLUMN action TYPE VARCHAR(30);
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('dismiss', 'hide', 'delete', 'ban_user',
+                      'set_active', 'set_suspended', 'set_shadow_banned', 'set_banned'));
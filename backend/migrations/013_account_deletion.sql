@@ -0,0 +1,32 @@
+-- Account deletion with a grace period. Deleted users' hazards are handed
+-- to a placeholder account instead of being deleted with them.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at
+    ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+-- Placeholder author of anonymised hazards; it cannot log in
+INSERT INTO users (id, username, email, password_hash, status, status_reason) VALUES
+    ('00000000-0000-0000-0000-000000000001', 'deleted_user', 'deleted@roadeye.invalid', '', 'banned', 'Placeholder for deleted accounts')
+ON CONFLICT (id) DO NOTHING;
+
+-- Refuse to delete users who still own hazards rather than cascading
+ALTER TABLE hazards DROP CONSTRAINT IF EXISTS hazards_user_id_fkey;
+ALTER TABLE hazards
+    ADD CONSTRAINT hazards_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT;
+
+-- Hazard history stays append-only, except that a deleted user's events are
+-- handed to the placeholder before the user goes
+CREATE OR REPLACE FUNCTION prevent_hazard_event_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.actor_id = '00000000-0000-0000-0000-000000000001'
+        AND (NEW.id, NEW.hazard_id, NEW.action, NEW.data, NEW.created_at)
+            IS NOT DISTINCT FROM (OLD.id, OLD.hazard_id, OLD.action, OLD.data, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'hazard_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,25 @@
+-- Per-user alert preferences. Users without a row are not alerted, since
+-- alerts need a location to measure the radius from.
+CREATE TABLE IF NOT EXISTS notification_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    location GEOGRAPHY(POINT, 4326) GENERATED ALWAYS AS (ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography) STORED,
+    radius_km DOUBLE PRECISION NOT NULL CHECK (radius_km > 0),
+    types TEXT[] NOT NULL DEFAULT '{}',
+    min_severity VARCHAR(20) NOT NULL DEFAULT 'low' CHECK (min_severity IN ('low', 'medium', 'high')),
+    -- Quiet hours in minutes after local midnight; they may wrap past midnight
+    quiet_start SMALLINT CHECK (quiet_start BETWEEN 0 AND 1439),
+    quiet_end SMALLINT CHECK (quiet_end BETWEEN 0 AND 1439),
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    push BOOLEAN NOT NULL DEFAULT TRUE,
+    email BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((latitude IS NULL) = (longitude IS NULL)),
+    CHECK ((quiet_start IS NULL) = (quiet_end IS NULL))
+);
+
+CREATE INDEX idx_notification_settings_location ON notification_settings USING GIST(location);
+
+CREATE TRIGGER update_notification_settings_updated_at BEFORE UPDATE ON notification_settings
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,6 @@
+-- Read state for the in-app notification inbox
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS read_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_inbox ON notifications(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications(user_id) WHERE read_at IS NULL;
@@ -0,0 +1,5 @@
+-- Language and units of a user's notifications
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(16) NOT NULL DEFAULT 'en',
+    ADD COLUMN IF NOT EXISTS units VARCHAR(10) NOT NULL DEFAULT 'metric'
+        CHECK (units IN ('metric', 'imperial'));
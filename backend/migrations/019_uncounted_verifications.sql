@@ -0,0 +1,16 @@
+-- Verifications by shadow-banned users are kept but not counted
+ALTER TABLE hazard_verifications ADD COLUMN IF NOT EXISTS counted BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE OR REPLACE FUNCTION increment_hazard_verify_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NOT NEW.counted THEN
+        RETURN NEW;
+    END IF;
+    UPDATE hazards
+    SET verify_count = verify_count + 1,
+        is_verified = CASE WHEN verify_count + 1 >= 3 THEN TRUE ELSE is_verified END
+    WHERE id = NEW.hazard_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+-- Planar index for bounding box queries, which treat boxes as flat
+-- longitude/latitude rectangles rather than great-circle polygons
+CREATE INDEX IF NOT EXISTS idx_hazards_location_geometry ON hazards USING GIST((location::geometry));
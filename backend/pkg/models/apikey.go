@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyScope string
+
+const (
+	ScopeHazardsRead  APIKeyScope = "hazards:read"
+	ScopeHazardsWrite APIKeyScope = "hazards:write"
+	ScopeFeedsRead    APIKeyScope = "feeds:read"
+	// ScopeWebhooksManage allows managing the owner's webhook subscriptions.
+	ScopeWebhooksManage APIKeyScope = "webhooks:manage"
+)
+
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case ScopeHazardsRead, ScopeHazardsWrite, ScopeFeedsRead, ScopeWebhooksManage:
+		return true
+	}
+	return false
+}
+
+// APIKey is a partner credential. Requests made with it act as UserID, the
+// partner's account, limited to Scopes.
+type APIKey struct {
+	ID     uuid.UUID     `json:"id" db:"id"`
+	UserID uuid.UUID     `json:"user_id" db:"user_id"`
+	Name   string        `json:"name" db:"name"`
+	Prefix string        `json:"prefix" db:"prefix"`
+	Scopes []APIKeyScope `json:"scopes" db:"scopes"`
+	// RateLimit is in requests per minute; nil uses the default policy.
+	RateLimit  *int       `json:"rate_limit,omitempty" db:"rate_limit"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedBy  *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+func (k *APIKey) HasScope(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyCreate struct {
+	UserID    uuid.UUID     `json:"user_id" validate:"required"`
+	Name      string        `json:"name" validate:"required,max=100"`
+	Scopes    []APIKeyScope `json:"scopes" validate:"required,min=1"`
+	RateLimit *int          `json:"rate_limit,omitempty" validate:"omitempty,min=1"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+}
+
+type APIKeyUpdate struct {
+	Name      *string       `json:"name,omitempty" validate:"omitempty,max=100"`
+	Scopes    []APIKeyScope `json:"scopes,omitempty"`
+	RateLimit *int          `json:"rate_limit,omitempty" validate:"omitempty,min=1"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+}
+
+// APIKeyCreated carries the plaintext key, which is only ever shown once.
+type APIKeyCreated struct {
+	*APIKey
+	Key string `json:"key"`
+}
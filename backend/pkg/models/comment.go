@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Comment struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	HazardID  uuid.UUID `json:"hazard_id" db:"hazard_id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	Body      string    `json:"body" db:"body"`
+	Edited    bool      `json:"edited" db:"edited"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// Shadowed comments come from shadow-banned users and are shown only to
+	// their author and moderators
+	Shadowed bool `json:"-"`
+}
+
+type CommentCreate struct {
+	Body string `json:"body" validate:"required,min=1,max=1000"`
+}
+
+type CommentPage struct {
+	Comments   []*Comment `json:"comments"`
+	NextCursor *string    `json:"next_cursor,omitempty"`
+}
+
+type CommentReportReason string
+
+const (
+	CommentReportSpam       CommentReportReason = "spam"
+	CommentReportOffensive  CommentReportReason = "offensive"
+	CommentReportHarassment CommentReportReason = "harassment"
+	CommentReportOffTopic   CommentReportReason = "off_topic"
+	CommentReportOther      CommentReportReason = "other"
+)
+
+func (r CommentReportReason) Valid() bool {
+	switch r {
+	case CommentReportSpam, CommentReportOffensive, CommentReportHarassment, CommentReportOffTopic, CommentReportOther:
+		return true
+	}
+	return false
+}
+
+type CommentReport struct {
+	Reason  CommentReportReason `json:"reason" validate:"required,oneof=spam offensive harassment off_topic other"`
+	Details *string             `json:"details,omitempty" validate:"omitempty,max=500"`
+}
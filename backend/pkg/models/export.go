@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DataExport is everything held about a user, as returned by
+// GET /users/me/export.
+type DataExport struct {
+	GeneratedAt     time.Time             `json:"generated_at"`
+	Profile         *ExportProfile        `json:"profile"`
+	Hazards         []*Hazard             `json:"hazards"`
+	Verifications   []*ExportVerification `json:"verifications"`
+	Comments        []*Comment            `json:"comments"`
+	Notifications   []*Notification       `json:"notifications"`
+	DeviceTokens    []*DeviceToken        `json:"device_tokens"`
+	LocationHistory []*LocationRecord     `json:"location_history"`
+}
+
+type ExportProfile struct {
+	User
+	Status              AccountStatus     `json:"status"`
+	TwoFactorEnabled    bool              `json:"two_factor_enabled"`
+	DeletionScheduledAt *time.Time        `json:"deletion_scheduled_at,omitempty"`
+	Identities          []*ExportIdentity `json:"linked_identities"`
+	// NotificationSettings is nil when none were saved.
+	NotificationSettings *NotificationSettings `json:"notification_settings,omitempty"`
+}
+
+type ExportIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     *string   `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportVerification struct {
+	HazardID  uuid.UUID `json:"hazard_id"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LocationRecord is a place the user's activity puts them at a given time.
+// Positions are not tracked otherwise, so these come from reports and
+// verifications. The alert location is part of the notification settings.
+type LocationRecord struct {
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Source     string    `json:"source"`
+}
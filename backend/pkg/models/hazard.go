@@ -24,6 +24,71 @@ const (
 	HazardSeverityHigh   HazardSeverity = "high"
 )
 
+type HazardStatus string
+
+const (
+	HazardStatusActive   HazardStatus = "active"
+	HazardStatusResolved HazardStatus = "resolved"
+)
+
+type HazardLane string
+
+const (
+	HazardLaneLeft     HazardLane = "left"
+	HazardLaneCenter   HazardLane = "center"
+	HazardLaneRight    HazardLane = "right"
+	HazardLaneShoulder HazardLane = "shoulder"
+	HazardLaneAll      HazardLane = "all"
+)
+
+type HazardSort string
+
+const (
+	HazardSortDistance HazardSort = "distance"
+	HazardSortRecent   HazardSort = "recent"
+	HazardSortSeverity HazardSort = "severity"
+)
+
+func (t HazardType) Valid() bool {
+	switch t {
+	case HazardTypePothole, HazardTypeDebris, HazardTypeAccident, HazardTypeConstruction, HazardTypeOther:
+		return true
+	}
+	return false
+}
+
+func (s HazardSeverity) Valid() bool {
+	switch s {
+	case HazardSeverityLow, HazardSeverityMedium, HazardSeverityHigh:
+		return true
+	}
+	return false
+}
+
+func (s HazardStatus) Valid() bool {
+	switch s {
+	case HazardStatusActive, HazardStatusResolved:
+		return true
+	}
+	return false
+}
+
+func (l HazardLane) Valid() bool {
+	switch l {
+	case HazardLaneLeft, HazardLaneCenter, HazardLaneRight, HazardLaneShoulder, HazardLaneAll:
+		return true
+	}
+	return false
+}
+
+func (s HazardSort) Valid() bool {
+	switch s {
+	case HazardSortDistance, HazardSortRecent, HazardSortSeverity:
+		return true
+	}
+	return false
+}
+
 type Hazard struct {
 	ID          uuid.UUID      `json:"id" db:"id"`
 	UserID      uuid.UUID      `json:"user_id" db:"user_id"`
@@ -36,9 +101,21 @@ type Hazard struct {
 	IsVerified  bool           `json:"verified" db:"is_verified"`
 	VerifyCount int            `json:"verify_count" db:"verify_count"`
 	ReportedBy  *string        `json:"reported_by,omitempty" db:"reported_by"`
-	CreatedAt   time.Time      `json:"timestamp" db:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
-	Distance    *float64       `json:"distance,omitempty" db:"distance"`
+	Bearing     *float64       `json:"bearing,omitempty" db:"bearing"`
+	Lane        *HazardLane    `json:"lane,omitempty" db:"lane"`
+	Status      HazardStatus   `json:"status" db:"status"`
+	// ClientKey is the batch idempotency key. It is kept private; batch
+	// results echo it back to the reporter.
+	ClientKey  *string    `json:"-" db:"client_key"`
+	CapturedAt *time.Time `json:"captured_at,omitempty" db:"captured_at"`
+	HiddenAt   *time.Time `json:"hidden_at,omitempty" db:"hidden_at"`
+	// Shadowed hazards come from shadow-banned users and are shown to no one
+	// but their reporter and moderators.
+	Shadowed    bool      `json:"-" db:"shadowed"`
+	CreatedAt   time.Time `json:"timestamp" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Distance    *float64  `json:"distance,omitempty" db:"distance"`
+	RouteOffset *float64  `json:"route_offset_m,omitempty" db:"route_offset"`
 }
 
 type HazardCreate struct {
@@ -48,6 +125,89 @@ type HazardCreate struct {
 	Severity    HazardSeverity `json:"severity" validate:"required,oneof=low medium high"`
 	Description *string        `json:"description,omitempty" validate:"omitempty,max=500"`
 	ImageBase64 *string        `json:"imageBase64,omitempty"`
+	Bearing     *float64       `json:"bearing,omitempty" validate:"omitempty,min=0,lt=360"`
+	Lane        *HazardLane    `json:"lane,omitempty" validate:"omitempty,oneof=left center right shoulder all"`
+}
+
+// HazardFilter holds the optional attribute filters shared by the hazard
+// listing queries.
+type HazardFilter struct {
+	Types        []HazardType     `json:"type,omitempty" validate:"omitempty,dive,oneof=pothole debris accident construction other"`
+	Severities   []HazardSeverity `json:"severity,omitempty" validate:"omitempty,dive,oneof=low medium high"`
+	VerifiedOnly bool             `json:"verified,omitempty"`
+	Status       *HazardStatus    `json:"status,omitempty" validate:"omitempty,oneof=active resolved"`
+	CreatedSince *time.Time       `json:"since,omitempty"`
+	ReporterID   *uuid.UUID       `json:"reporter,omitempty"`
+	// ViewerID is the caller, who also sees their own shadowed hazards.
+	ViewerID uuid.UUID `json:"-"`
+}
+
+// HazardAction is what a hazard history event records.
+type HazardAction string
+
+const (
+	HazardActionCreated       HazardAction = "created"
+	HazardActionUpdated       HazardAction = "updated"
+	HazardActionVerified      HazardAction = "verified"
+	HazardActionStatusChanged HazardAction = "status_changed"
+	HazardActionDeleted       HazardAction = "deleted"
+	HazardActionFlagged       HazardAction = "flagged"
+	HazardActionHidden        HazardAction = "hidden"
+	HazardActionUnhidden      HazardAction = "unhidden"
+)
+
+// HazardEvent is one entry in a hazard's audit history.
+type HazardEvent struct {
+	ID            uuid.UUID              `json:"id" db:"id"`
+	HazardID      uuid.UUID              `json:"hazard_id" db:"hazard_id"`
+	ActorID       *uuid.UUID             `json:"actor_id,omitempty" db:"actor_id"`
+	ActorUsername *string                `json:"actor_username,omitempty" db:"actor_username"`
+	Action        HazardAction           `json:"action" db:"action"`
+	Data          map[string]interface{} `json:"data,omitempty" db:"data"`
+	CreatedAt     time.Time              `json:"created_at" db:"created_at"`
+}
+
+// HazardUpdate is a partial edit; nil fields are left unchanged.
+type HazardUpdate struct {
+	Type        *HazardType     `json:"type,omitempty" validate:"omitempty,oneof=pothole debris accident construction other"`
+	Severity    *HazardSeverity `json:"severity,omitempty" validate:"omitempty,oneof=low medium high"`
+	Description *string         `json:"description,omitempty" validate:"omitempty,max=500"`
+	Bearing     *float64        `json:"bearing,omitempty" validate:"omitempty,min=0,lt=360"`
+	Lane        *HazardLane     `json:"lane,omitempty" validate:"omitempty,oneof=left center right shoulder all"`
+	Status      *HazardStatus   `json:"status,omitempty" validate:"omitempty,oneof=active resolved"`
+}
+
+type HazardDelete struct {
+	Reason *string `json:"reason,omitempty" validate:"omitempty,max=500"`
+}
+
+// HazardBatchItem is one report queued offline by the client. The
+// idempotency key is unique per user; resubmitting it returns the hazard
+// created the first time.
+type HazardBatchItem struct {
+	HazardCreate
+	IdempotencyKey string     `json:"idempotency_key" validate:"required,max=100"`
+	CapturedAt     *time.Time `json:"captured_at,omitempty"`
+}
+
+type HazardBatchRequest struct {
+	Items []HazardBatchItem `json:"items" validate:"required,min=1,max=100,dive"`
+}
+
+type BatchItemStatus string
+
+const (
+	BatchItemCreated   BatchItemStatus = "created"
+	BatchItemDuplicate BatchItemStatus = "duplicate"
+	BatchItemFailed    BatchItemStatus = "failed"
+)
+
+type HazardBatchResult struct {
+	Index          int             `json:"index"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	Status         BatchItemStatus `json:"status"`
+	Hazard         *Hazard         `json:"hazard,omitempty"`
+	Error          string          `json:"error,omitempty"`
 }
 
 type HazardQuery struct {
@@ -55,6 +215,110 @@ type HazardQuery struct {
 	Longitude float64  `json:"lon" validate:"required,longitude"`
 	Radius    *float64 `json:"radius,omitempty" validate:"omitempty,min=0.1,max=50"`
 	Limit     *int     `json:"limit,omitempty" validate:"omitempty,min=1,max=100"`
+	HazardFilter
+	Sort   HazardSort `json:"sort,omitempty" validate:"omitempty,oneof=distance recent severity"`
+	Cursor string     `json:"cursor,omitempty"`
+}
+
+// DriveQuery describes a moving vehicle. SpeedKmh scales the look-ahead
+// distance and Heading is the direction of travel in degrees from north.
+type DriveQuery struct {
+	Latitude     float64   `json:"lat" validate:"required,latitude"`
+	Longitude    float64   `json:"lon" validate:"required,longitude"`
+	SpeedKmh     float64   `json:"speed" validate:"min=0,max=250"`
+	Heading      float64   `json:"heading" validate:"min=0,lt=360"`
+	ToleranceDeg *float64  `json:"tolerance,omitempty" validate:"omitempty,min=5,max=90"`
+	ViewerID     uuid.UUID `json:"-"`
+}
+
+type TombstoneReason string
+
+const (
+	TombstoneDeleted  TombstoneReason = "deleted"
+	TombstoneResolved TombstoneReason = "resolved"
+)
+
+// HazardTombstone tells a syncing client to drop a hazard it holds locally.
+type HazardTombstone struct {
+	ID     uuid.UUID       `json:"id"`
+	Reason TombstoneReason `json:"reason"`
+}
+
+type HazardChanges struct {
+	Upserts    []*Hazard          `json:"upserts"`
+	Tombstones []*HazardTombstone `json:"tombstones"`
+	NextCursor string             `json:"next_cursor"`
+	HasMore    bool               `json:"has_more"`
+}
+
+// BoundingBox is a longitude/latitude box. A box crossing the antimeridian
+// has MinLon east of MaxLon, such as 170 to -170.
+type BoundingBox struct {
+	MinLon float64 `json:"min_lon"`
+	MinLat float64 `json:"min_lat"`
+	MaxLon float64 `json:"max_lon"`
+	MaxLat float64 `json:"max_lat"`
+}
+
+// CrossesAntimeridian reports whether the box wraps past 180 degrees.
+func (b BoundingBox) CrossesAntimeridian() bool {
+	return b.MinLon > b.MaxLon
+}
+
+// Width returns the longitude span of the box in degrees.
+func (b BoundingBox) Width() float64 {
+	if b.CrossesAntimeridian() {
+		return b.MaxLon - b.MinLon + 360
+	}
+	return b.MaxLon - b.MinLon
+}
+
+// GeoJSONLineString is a GeoJSON LineString geometry; coordinates are
+// [longitude, latitude] pairs.
+type GeoJSONLineString struct {
+	Type        string       `json:"type" validate:"required,eq=LineString"`
+	Coordinates [][2]float64 `json:"coordinates" validate:"required,min=2"`
+}
+
+// GeoJSONPolygon is a GeoJSON Polygon geometry: an outer ring followed by
+// any holes, each a closed ring of [longitude, latitude] pairs.
+type GeoJSONPolygon struct {
+	Type        string         `json:"type" validate:"required,eq=Polygon"`
+	Coordinates [][][2]float64 `json:"coordinates" validate:"required,min=1"`
+}
+
+// RouteQuery selects hazards within CorridorM metres either side of a route
+// given as an encoded polyline or a GeoJSON LineString.
+type RouteQuery struct {
+	Polyline   *string            `json:"polyline,omitempty"`
+	LineString *GeoJSONLineString `json:"geometry,omitempty"`
+	CorridorM  *float64           `json:"corridor_m,omitempty" validate:"omitempty,min=1,max=500"`
+	Limit      *int               `json:"limit,omitempty" validate:"omitempty,min=1,max=500"`
+	HazardFilter
+}
+
+type ViewportQuery struct {
+	BBox BoundingBox `json:"bbox"`
+	HazardFilter
+}
+
+// HazardCluster summarises the hazards that fall into one grid cell of a
+// viewport too dense to return as individual markers.
+type HazardCluster struct {
+	Count       int            `json:"count"`
+	Latitude    float64        `json:"latitude"`
+	Longitude   float64        `json:"longitude"`
+	MaxSeverity HazardSeverity `json:"max_severity"`
+}
+
+type HazardViewport struct {
+	Hazards  []*Hazard        `json:"hazards,omitempty"`
+	Clusters []*HazardCluster `json:"clusters,omitempty"`
+}
+
+type HazardPage struct {
+	Hazards    []*Hazard `json:"hazards"`
+	NextCursor *string   `json:"next_cursor,omitempty"`
 }
 
 type DetectionRequest struct {
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type FlagReason string
+
+const (
+	FlagReasonSpam           FlagReason = "spam"
+	FlagReasonFake           FlagReason = "fake"
+	FlagReasonOffensiveImage FlagReason = "offensive_image"
+	FlagReasonDuplicate      FlagReason = "duplicate"
+)
+
+func (r FlagReason) Valid() bool {
+	switch r {
+	case FlagReasonSpam, FlagReasonFake, FlagReasonOffensiveImage, FlagReasonDuplicate:
+		return true
+	}
+	return false
+}
+
+type HazardFlag struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	HazardID  uuid.UUID  `json:"hazard_id" db:"hazard_id"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`
+	Reason    FlagReason `json:"reason" db:"reason"`
+	Details   *string    `json:"details,omitempty" db:"details"`
+	Weight    int        `json:"weight" db:"weight"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type FlagCreate struct {
+	Reason  FlagReason `json:"reason" validate:"required,oneof=spam fake offensive_image duplicate"`
+	Details *string    `json:"details,omitempty" validate:"omitempty,max=500"`
+}
+
+// ModerationItem is one flagged hazard in the moderator queue.
+type ModerationItem struct {
+	Hazard     *Hazard       `json:"hazard"`
+	FlagWeight int           `json:"flag_weight"`
+	FlagCount  int           `json:"flag_count"`
+	Flags      []*HazardFlag `json:"flags"`
+}
+
+type ModerationAction string
+
+const (
+	ModerationDismiss ModerationAction = "dismiss"
+	ModerationHide    ModerationAction = "hide"
+	ModerationDelete  ModerationAction = "delete"
+	ModerationBanUser ModerationAction = "ban_user"
+)
+
+func (a ModerationAction) Valid() bool {
+	switch a {
+	case ModerationDismiss, ModerationHide, ModerationDelete, ModerationBanUser:
+		return true
+	}
+	return false
+}
+
+type ModerationRequest struct {
+	Action ModerationAction `json:"action" validate:"required,oneof=dismiss hide delete ban_user"`
+	Reason *string          `json:"reason,omitempty" validate:"omitempty,max=500"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,14 +17,39 @@ type DeviceToken struct {
 }
 
 type Notification struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	UserID    uuid.UUID `json:"user_id" db:"user_id"`
-	HazardID  uuid.UUID `json:"hazard_id" db:"hazard_id"`
-	Title     string    `json:"title" db:"title"`
-	Body      string    `json:"body" db:"body"`
-	Data      string    `json:"data" db:"data"` // JSON string
-	Sent      bool      `json:"sent" db:"sent"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID       uuid.UUID `json:"id" db:"id"`
+	UserID   uuid.UUID `json:"user_id" db:"user_id"`
+	HazardID uuid.UUID `json:"hazard_id" db:"hazard_id"`
+	Title    string    `json:"title" db:"title"`
+	Body     string    `json:"body" db:"body"`
+	Data     string    `json:"data" db:"data"` // JSON string
+	Sent     bool      `json:"sent" db:"sent"`
+	// ReadAt is nil while the notification is unread.
+	ReadAt    *time.Time `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	// Hazard is the hazard as it is now, set in the inbox.
+	Hazard *NotificationHazard `json:"hazard,omitempty"`
+}
+
+// NotificationHazard is the current state of a notification's hazard, so
+// that an old alert shows whether the hazard has since been resolved.
+// Removed is set once the hazard was deleted or hidden by moderators;
+// the other details are then withheld.
+type NotificationHazard struct {
+	ID        uuid.UUID      `json:"id"`
+	Removed   bool           `json:"removed"`
+	Type      HazardType     `json:"type,omitempty"`
+	Severity  HazardSeverity `json:"severity,omitempty"`
+	Status    HazardStatus   `json:"status,omitempty"`
+	Latitude  float64        `json:"latitude,omitempty"`
+	Longitude float64        `json:"longitude,omitempty"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+type NotificationPage struct {
+	Notifications []*Notification `json:"notifications"`
+	UnreadCount   int             `json:"unread_count"`
+	NextCursor    *string         `json:"next_cursor,omitempty"`
 }
 
 type NotificationPayload struct {
@@ -37,3 +63,84 @@ type RegisterTokenRequest struct {
 	Token    string `json:"token" validate:"required"`
 	Platform string `json:"platform" validate:"required,oneof=ios android web"`
 }
+
+// NotificationSettings decide which hazards a user is alerted about and
+// how. Alerts cover hazards within RadiusKm of Latitude/Longitude, usually
+// the user's home or last known position as reported by the app; without a
+// location the user is not alerted.
+type NotificationSettings struct {
+	Latitude    *float64             `json:"latitude,omitempty" validate:"omitempty,min=-90,max=90"`
+	Longitude   *float64             `json:"longitude,omitempty" validate:"omitempty,min=-180,max=180"`
+	RadiusKm    float64              `json:"radius_km" validate:"required,min=0.1,max=50"`
+	Types       []HazardType         `json:"types" validate:"omitempty,dive,oneof=pothole debris accident construction other"`
+	MinSeverity HazardSeverity       `json:"min_severity" validate:"required,oneof=low medium high"`
+	QuietHours  *QuietHours          `json:"quiet_hours,omitempty"`
+	TimeZone    string               `json:"time_zone" validate:"required"`
+	Channels    NotificationChannels `json:"channels"`
+	UpdatedAt   *time.Time           `json:"updated_at,omitempty"`
+}
+
+type NotificationChannels struct {
+	Push  bool `json:"push"`
+	Email bool `json:"email"`
+}
+
+// QuietHours is a daily window, in "HH:MM" local time, without alerts. It
+// wraps past midnight when End is before Start.
+type QuietHours struct {
+	Start string `json:"start" validate:"required"`
+	End   string `json:"end" validate:"required"`
+}
+
+// Minutes returns Start and End in minutes after midnight.
+func (q *QuietHours) Minutes() (start, end int, err error) {
+	if start, err = parseClock(q.Start); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(q.End); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// Contains reports whether t falls inside the quiet hours in loc.
+func (q *QuietHours) Contains(t time.Time, loc *time.Location) bool {
+	start, end, err := q.Minutes()
+	if err != nil || start == end {
+		return false
+	}
+	local := t.In(loc)
+	m := local.Hour()*60 + local.Minute()
+	if start < end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
+
+// EndAfter returns the first time after t at which the quiet hours end in
+// loc.
+func (q *QuietHours) EndAfter(t time.Time, loc *time.Location) time.Time {
+	_, end, err := q.Minutes()
+	if err != nil {
+		return t
+	}
+	local := t.In(loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+	if !next.After(local) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, end/60, end%60, 0, 0, loc)
+	}
+	return next
+}
+
+// ClockString formats minutes after midnight as "HH:MM".
+func ClockString(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 6, 10, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		at    time.Time
+		want  bool
+	}{
+		{"same day, inside", QuietHours{"13:00", "15:00"}, at(14, 0), true},
+		{"same day, at start", QuietHours{"13:00", "15:00"}, at(13, 0), true},
+		{"same day, at end", QuietHours{"13:00", "15:00"}, at(15, 0), false},
+		{"same day, before", QuietHours{"13:00", "15:00"}, at(12, 59), false},
+		{"wraps, late evening", QuietHours{"22:00", "07:00"}, at(23, 30), true},
+		{"wraps, after midnight", QuietHours{"22:00", "07:00"}, at(0, 0), true},
+		{"wraps, early morning", QuietHours{"22:00", "07:00"}, at(6, 59), true},
+		{"wraps, at end", QuietHours{"22:00", "07:00"}, at(7, 0), false},
+		{"wraps, midday", QuietHours{"22:00", "07:00"}, at(12, 0), false},
+		{"empty window", QuietHours{"08:00", "08:00"}, at(8, 0), false},
+		{"invalid", QuietHours{"8pm", "07:00"}, at(23, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quiet.Contains(tt.at, time.UTC); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.at.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursContainsInTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	quiet := QuietHours{"22:00", "07:00"}
+
+	// 06:00 UTC is 23:00 the day before in Los Angeles, in summer
+	if !quiet.Contains(time.Date(2024, 6, 10, 6, 0, 0, 0, time.UTC), loc) {
+		t.Error("23:00 local is not quiet")
+	}
+	// 20:00 UTC is 13:00 in Los Angeles
+	if quiet.Contains(time.Date(2024, 6, 10, 20, 0, 0, 0, time.UTC), loc) {
+		t.Error("13:00 local is quiet")
+	}
+}
+
+func TestQuietHoursEndAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		at    time.Time
+		want  time.Time
+	}{
+		{
+			name:  "wraps, before midnight",
+			quiet: QuietHours{"22:00", "07:00"},
+			at:    time.Date(2024, 6, 10, 23, 30, 0, 0, time.UTC),
+			want:  time.Date(2024, 6, 11, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "wraps, after midnight",
+			quiet: QuietHours{"22:00", "07:00"},
+			at:    time.Date(2024, 6, 11, 1, 15, 0, 0, time.UTC),
+			want:  time.Date(2024, 6, 11, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "same day",
+			quiet: QuietHours{"13:00", "15:30"},
+			at:    time.Date(2024, 6, 10, 14, 0, 0, 0, time.UTC),
+			want:  time.Date(2024, 6, 10, 15, 30, 0, 0, time.UTC),
+		},
+		{
+			name:  "end of month",
+			quiet: QuietHours{"22:00", "07:00"},
+			at:    time.Date(2024, 6, 30, 22, 0, 0, 0, time.UTC),
+			want:  time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "exactly at the end",
+			quiet: QuietHours{"22:00", "07:00"},
+			at:    time.Date(2024, 6, 11, 7, 0, 0, 0, time.UTC),
+			want:  time.Date(2024, 6, 12, 7, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quiet.EndAfter(tt.at, time.UTC); !got.Equal(tt.want) {
+				t.Errorf("EndAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursEndAfterInTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	quiet := QuietHours{"22:00", "07:00"}
+
+	// The night clocks go forward, 23:00 CET on 30 March 2024
+	at := time.Date(2024, 3, 30, 22, 0, 0, 0, time.UTC)
+	want := time.Date(2024, 3, 31, 7, 0, 0, 0, loc)
+	if got := quiet.EndAfter(at, loc); !got.Equal(want) {
+		t.Errorf("EndAfter() = %v, want %v", got, want)
+	}
+	if got := want.Sub(at); got != 7*time.Hour {
+		t.Errorf("quiet night lasted %v, want 7h", got)
+	}
+}
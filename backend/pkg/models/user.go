@@ -6,21 +6,120 @@ import (
 	"github.com/google/uuid"
 )
 
+type UserRole string
+
+const (
+	UserRoleUser      UserRole = "user"
+	UserRoleModerator UserRole = "moderator"
+	UserRoleAuthority UserRole = "authority"
+	UserRoleAdmin     UserRole = "admin"
+)
+
+// CanModerate reports whether the role may act on other users' content.
+func (r UserRole) CanModerate() bool {
+	return r == UserRoleModerator || r == UserRoleAdmin
+}
+
+// RequiresTwoFactor reports whether the role's privileges are only granted
+// after a two-factor login.
+func (r UserRole) RequiresTwoFactor() bool {
+	return r == UserRoleModerator || r == UserRoleAuthority || r == UserRoleAdmin
+}
+
+type AccountStatus string
+
+const (
+	AccountStatusActive    AccountStatus = "active"
+	AccountStatusSuspended AccountStatus = "suspended"
+	// AccountStatusShadowBanned users can keep posting, but their hazards are
+	// visible only to themselves and moderators.
+	AccountStatusShadowBanned AccountStatus = "shadow_banned"
+	AccountStatusBanned       AccountStatus = "banned"
+)
+
+func (s AccountStatus) Valid() bool {
+	switch s {
+	case AccountStatusActive, AccountStatusSuspended, AccountStatusShadowBanned, AccountStatusBanned:
+		return true
+	}
+	return false
+}
+
+// TrustLevel decides how many hazards a user may report per day.
+type TrustLevel string
+
+const (
+	TrustLevelNew     TrustLevel = "new"
+	TrustLevelRegular TrustLevel = "regular"
+	TrustLevelTrusted TrustLevel = "trusted"
+	// TrustLevelStaff covers moderators, authorities and admins, who have no
+	// report quota.
+	TrustLevelStaff TrustLevel = "staff"
+)
+
+// UnitSystem decides how distances are shown to a user.
+type UnitSystem string
+
+const (
+	UnitsMetric   UnitSystem = "metric"
+	UnitsImperial UnitSystem = "imperial"
+)
+
+func (u UnitSystem) Valid() bool {
+	return u == UnitsMetric || u == UnitsImperial
+}
+
 type User struct {
 	ID           uuid.UUID `json:"id" db:"id"`
 	Username     string    `json:"username" db:"username"`
 	Email        string    `json:"email" db:"email"`
 	PasswordHash string    `json:"-" db:"password_hash"`
 	Points       int       `json:"points" db:"points"`
+	Role         UserRole  `json:"role" db:"role"`
 	Avatar       *string   `json:"avatar,omitempty" db:"avatar"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	// Locale and Units are the user's preferences for notifications. They
+	// are only loaded for the user's own profile.
+	Locale    string     `json:"locale,omitempty" db:"locale"`
+	Units     UnitSystem `json:"units,omitempty" db:"units"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Account is the enforcement state of a user. A suspension that has run
+// out leaves the account active.
+type Account struct {
+	UserID         uuid.UUID     `json:"user_id" db:"id"`
+	Status         AccountStatus `json:"status" db:"status"`
+	SuspendedUntil *time.Time    `json:"suspended_until,omitempty" db:"suspended_until"`
+	Reason         *string       `json:"reason,omitempty" db:"status_reason"`
+}
+
+// Effective returns the status in force at now.
+func (a *Account) Effective(now time.Time) AccountStatus {
+	if a.Status == AccountStatusSuspended && a.SuspendedUntil != nil && !now.Before(*a.SuspendedUntil) {
+		return AccountStatusActive
+	}
+	return a.Status
+}
+
+type AccountStatusUpdate struct {
+	Status AccountStatus `json:"status" validate:"required,oneof=active suspended shadow_banned banned"`
+	Until  *time.Time    `json:"until,omitempty"`
+	Reason *string       `json:"reason,omitempty" validate:"omitempty,max=500"`
+}
+
+// ReportQuota is a user's daily hazard allowance. Limit is zero for
+// unlimited.
+type ReportQuota struct {
+	TrustLevel TrustLevel `json:"trust_level"`
+	Limit      int        `json:"limit"`
+	Used       int        `json:"used"`
 }
 
 type UserCreate struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Password string `json:"password" validate:"required,max=256"`
 }
 
 type UserLogin struct {
@@ -28,13 +127,89 @@ type UserLogin struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type UnlockRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// OIDCAuthorization starts a browser sign-in: the client sends the user to
+// AuthorizationURL and posts the returned code and state to the callback.
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+type OIDCCallback struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+	// Password confirms linking to an existing account that has one.
+	Password string `json:"password,omitempty"`
+}
+
+// OIDCTokenExchange signs in with an ID token a native app obtained from the
+// provider's SDK.
+type OIDCTokenExchange struct {
+	IDToken  string `json:"id_token" validate:"required"`
+	Nonce    string `json:"nonce" validate:"required"`
+	Password string `json:"password,omitempty"`
+}
+
 type UserUpdate struct {
 	Username *string `json:"username,omitempty" validate:"omitempty,min=3,max=50"`
 	Avatar   *string `json:"avatar,omitempty"`
 }
 
+// UserPreferences are the language, as a BCP 47 tag such as "en" or
+// "pt-BR", and the units notifications are written in. The handler checks
+// both.
+type UserPreferences struct {
+	Locale string     `json:"locale"`
+	Units  UnitSystem `json:"units"`
+}
+
+// AccountDeletion asks for the account to be deleted after the grace
+// period. Accounts with a password must confirm it.
+type AccountDeletion struct {
+	Password string `json:"password,omitempty"`
+}
+
+type DeletionSchedule struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+}
+
 type AuthResponse struct {
 	User         *User  `json:"user"`
 	Token        string `json:"token"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	// TwoFactorSetupRequired tells privileged users that their role is not
+	// in effect until they enable two-factor authentication.
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// TwoFactorChallenge is returned by login in place of tokens when the
+// account has two-factor authentication enabled.
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+// TwoFactorVerify completes a challenged login with either a TOTP code or a
+// recovery code.
+type TwoFactorVerify struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
 }
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookSubscription sends hazard events inside Area to URL. Types and
+// MinSeverity narrow down which hazards are sent; an empty Types matches
+// every type.
+type WebhookSubscription struct {
+	ID          uuid.UUID       `json:"id" db:"id"`
+	UserID      uuid.UUID       `json:"user_id" db:"user_id"`
+	URL         string          `json:"url" db:"url"`
+	Area        *GeoJSONPolygon `json:"area" db:"area"`
+	Types       []HazardType    `json:"types" db:"types"`
+	MinSeverity HazardSeverity  `json:"min_severity" db:"min_severity"`
+	// ConsecutiveFailures counts failed attempts since the last success. The
+	// subscription is disabled when it reaches the worker's limit.
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookCreate takes the area as either a bounding box or a polygon.
+type WebhookCreate struct {
+	URL         string          `json:"url" validate:"required,url"`
+	BBox        *BoundingBox    `json:"bbox,omitempty"`
+	Polygon     *GeoJSONPolygon `json:"polygon,omitempty"`
+	Types       []HazardType    `json:"types,omitempty" validate:"omitempty,dive,oneof=pothole debris accident construction other"`
+	MinSeverity *HazardSeverity `json:"min_severity,omitempty" validate:"omitempty,oneof=low medium high"`
+}
+
+// WebhookUpdate changes the given fields. Setting Enabled to true re-enables
+// a disabled subscription and clears its failure count.
+type WebhookUpdate struct {
+	URL         *string         `json:"url,omitempty" validate:"omitempty,url"`
+	BBox        *BoundingBox    `json:"bbox,omitempty"`
+	Polygon     *GeoJSONPolygon `json:"polygon,omitempty"`
+	Types       []HazardType    `json:"types,omitempty" validate:"omitempty,dive,oneof=pothole debris accident construction other"`
+	MinSeverity *HazardSeverity `json:"min_severity,omitempty" validate:"omitempty,oneof=low medium high"`
+	Enabled     *bool           `json:"enabled,omitempty"`
+}
+
+// WebhookCreated carries the signing secret, which is only ever shown once.
+type WebhookCreated struct {
+	*WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+func (s WebhookDeliveryStatus) Valid() bool {
+	switch s {
+	case WebhookDeliveryPending, WebhookDeliverySucceeded, WebhookDeliveryFailed:
+		return true
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a subscription.
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id" db:"id"`
+	SubscriptionID uuid.UUID             `json:"subscription_id" db:"subscription_id"`
+	EventID        uuid.UUID             `json:"event_id" db:"event_id"`
+	EventType      string                `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage       `json:"payload" db:"payload"`
+	Status         WebhookDeliveryStatus `json:"status" db:"status"`
+	Attempts       int                   `json:"attempts" db:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at,omitempty" db:"next_attempt_at"`
+	ResponseStatus *int                  `json:"response_status,omitempty" db:"response_status"`
+	LastError      *string               `json:"last_error,omitempty" db:"last_error"`
+	// RedeliveryOf is set on deliveries made through the redeliver endpoint.
+	RedeliveryOf *uuid.UUID `json:"redelivery_of,omitempty" db:"redelivery_of"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}